@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/netstr"
+	"github.com/spf13/cobra"
+)
+
+const usageAddressBookFile = "address book file (defaults to ADDRESS_BOOK_FILE)"
+
+// newAddressBookCmd creates the commands to manage the address book of the entry node.
+func newAddressBookCmd() *cobra.Command {
+	addressBookCmd := &cobra.Command{
+		Use:   "addressbook",
+		Short: "manage the names of .nostr destinations used by the entry node",
+	}
+	addressBookCmd.PersistentFlags().String("file", "", usageAddressBookFile)
+	addressBookCmd.AddCommand(
+		&cobra.Command{
+			Use:   "add <name>.nws <npub|nprofile|domain.nostr>",
+			Short: "add or replace a name",
+			Args:  cobra.ExactArgs(2),
+			RunE:  addAddressBookEntry,
+		},
+		&cobra.Command{
+			Use:   "list",
+			Short: "list the names of the address book file",
+			Args:  cobra.NoArgs,
+			RunE:  listAddressBookEntries,
+		},
+		&cobra.Command{
+			Use:   "remove <name>.nws",
+			Short: "remove a name",
+			Args:  cobra.ExactArgs(1),
+			RunE:  removeAddressBookEntry,
+		},
+	)
+	return addressBookCmd
+}
+
+// loadAddressBook loads the address book from the file flag or the entry configuration.
+func loadAddressBook(cmd *cobra.Command) (*netstr.AddressBook, error) {
+	path, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get address book file: %w", err)
+	}
+	if path == "" {
+		cfg, err := loadConfig[config.EntryConfig]()
+		if err != nil {
+			return nil, err
+		}
+		path = cfg.AddressBookFile
+	}
+	return netstr.LoadAddressBook(path)
+}
+
+func addAddressBookEntry(cmd *cobra.Command, args []string) error {
+	book, err := loadAddressBook(cmd)
+	if err != nil {
+		return err
+	}
+	entry, err := book.Add(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	cmd.Printf("added %s -> %s\n", entry.Name, entry.Destination())
+	return nil
+}
+
+func listAddressBookEntries(cmd *cobra.Command, _ []string) error {
+	book, err := loadAddressBook(cmd)
+	if err != nil {
+		return err
+	}
+	for _, entry := range book.Entries() {
+		cmd.Printf("%s\t%s\n", entry.Name, entry.Destination())
+	}
+	return nil
+}
+
+func removeAddressBookEntry(cmd *cobra.Command, args []string) error {
+	book, err := loadAddressBook(cmd)
+	if err != nil {
+		return err
+	}
+	if err = book.Remove(args[0]); err != nil {
+		return err
+	}
+	cmd.Printf("removed %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/exit"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+)
+
+const (
+	usageExposeRelay   = "relay of the exit node (repeatable, defaults to NOSTR_RELAYS or the default relays)"
+	usageExposeKeyFile = "file with the private key of the exit node, a new key is generated and saved if it does not exist"
+)
+
+// newExposeCmd creates the command that starts an exit node for a single local service.
+func newExposeCmd() *cobra.Command {
+	exposeCmd := &cobra.Command{
+		Use:   "expose <[host:]port>",
+		Short: "expose a local service over Nostr with a one-shot exit node",
+		Example: "  nws expose localhost:8080\n" +
+			"  nws expose 8080 --relay wss://relay.damus.io --key-file nws.key",
+		Args: cobra.ExactArgs(1),
+		RunE: startExpose,
+	}
+	exposeCmd.Flags().StringArray("relay", nil, usageExposeRelay)
+	exposeCmd.Flags().String("key-file", "", usageExposeKeyFile)
+	return exposeCmd
+}
+
+func startExpose(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig[config.ExitConfig]()
+	if err != nil {
+		return err
+	}
+	if err = updateExposeConfig(cmd, cfg, args[0]); err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+	// the exit node is stopped by Shutdown, so its context must outlive the signal context
+	ctx := context.WithoutCancel(cmd.Context())
+	exitNode, err := exit.New(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to start exit node: %w", err)
+	}
+	domain, err := exitNode.Domain()
+	if err != nil {
+		return errors.Join(err, exitNode.Shutdown(ctx))
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Exposing %s over Nostr, press Ctrl-C to stop\n  domain:   %s\n  nprofile: %s\n",
+		cfg.BackendHost, domain, exitNode.Profile())
+	return serve(cmd, func() error { return exitNode.ListenAndServe(ctx) }, exitNode.Shutdown)
+}
+
+// updateExposeConfig configures the exit node to only connect to the exposed service.
+func updateExposeConfig(cmd *cobra.Command, cfg *config.ExitConfig, target string) error {
+	if !strings.Contains(target, ":") {
+		target = "localhost:" + target
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("invalid service address %q: %w", target, err)
+	}
+	cfg.BackendHost = target
+	cfg.BackendOnly = true
+	cfg.BindDisabled = true
+	relays, err := cmd.Flags().GetStringArray("relay")
+	if err != nil {
+		return fmt.Errorf("failed to get relays: %w", err)
+	}
+	if len(relays) > 0 {
+		cfg.NostrRelays = relays
+	}
+	if len(cfg.NostrRelays) == 0 {
+		cfg.NostrRelays = config.DefaultRelays
+	}
+	keyFile, err := cmd.Flags().GetString("key-file")
+	if err != nil {
+		return fmt.Errorf("failed to get key file: %w", err)
+	}
+	if keyFile != "" {
+		if cfg.NostrPrivateKey, err = loadOrCreateKey(keyFile); err != nil {
+			return err
+		}
+	} else if cfg.NostrPrivateKey == "" {
+		cfg.NostrPrivateKey = nostr.GeneratePrivateKey()
+		slog.Warn("using a temporary private key, the address changes on the next start unless --key-file is set")
+	}
+	return nil
+}
+
+// loadOrCreateKey reads the hex or nsec private key of the file. If the file does not exist,
+// a new key is generated and saved.
+func loadOrCreateKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := nostr.GeneratePrivateKey()
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return "", fmt.Errorf("failed to create key file: %w", err)
+		}
+		_, err = fmt.Fprintln(file, key)
+		if err = errors.Join(err, file.Close()); err != nil {
+			return "", fmt.Errorf("failed to write key file: %w", err)
+		}
+		slog.Info("saved new private key", "file", path)
+		return key, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if strings.HasPrefix(key, "nsec") {
+		_, value, err := nip19.Decode(key)
+		if err != nil {
+			return "", fmt.Errorf("invalid private key in %s: %w", path, err)
+		}
+		key = value.(string)
+	}
+	if _, err = nostr.GetPublicKey(key); err != nil {
+		return "", fmt.Errorf("invalid private key in %s: %w", path, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/proxy"
+	"github.com/spf13/cobra"
+)
+
+const (
+	usageForwardListen = "local address to listen on, e.g. 127.0.0.1:5432"
+	usageForwardTo     = "destination with port: .nostr domain, npub, nprofile or address book name"
+	usageForwardConfig = "JSON file with a list of forwards ({\"listen\": ..., \"to\": ...})"
+)
+
+// newForwardCmd creates the command that forwards local ports to NWS destinations.
+func newForwardCmd() *cobra.Command {
+	forwardCmd := &cobra.Command{
+		Use:   "forward",
+		Short: "forward local TCP ports to NWS destinations",
+		Example: "  nws forward --listen 127.0.0.1:5432 --to nprofile1...:5432\n" +
+			"  nws forward --config forwards.json",
+		Args: cobra.NoArgs,
+		RunE: startForward,
+	}
+	forwardCmd.Flags().String("listen", "", usageForwardListen)
+	forwardCmd.Flags().String("to", "", usageForwardTo)
+	forwardCmd.Flags().String("config", "", usageForwardConfig)
+	forwardCmd.MarkFlagsRequiredTogether("listen", "to")
+	forwardCmd.MarkFlagsOneRequired("listen", "config")
+	return forwardCmd
+}
+
+func startForward(cmd *cobra.Command, _ []string) error {
+	forwards, err := forwardsFromFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+	cfg, err := loadConfig[config.EntryConfig]()
+	if err != nil {
+		return err
+	}
+	if len(cfg.NostrRelays) == 0 {
+		slog.Info("No relays provided, using default relays")
+		cfg.NostrRelays = config.DefaultRelays
+	}
+	forwarder, err := proxy.NewForwarder(context.WithoutCancel(cmd.Context()), cfg, forwards)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+	return serve(cmd, forwarder.Start, forwarder.Shutdown)
+}
+
+// forwardsFromFlags returns the forwards of the config file and the listen and to flags.
+func forwardsFromFlags(cmd *cobra.Command) ([]proxy.Forward, error) {
+	var forwards []proxy.Forward
+	path, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forwards file: %w", err)
+	}
+	if path != "" {
+		if forwards, err = proxy.LoadForwards(path); err != nil {
+			return nil, err
+		}
+	}
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get listen address: %w", err)
+	}
+	to, err := cmd.Flags().GetString("to")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination: %w", err)
+	}
+	if listen != "" {
+		forwards = append(forwards, proxy.Forward{Listen: listen, To: to})
+	}
+	return forwards, nil
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/asmogo/nws/config"
 	"github.com/asmogo/nws/exit"
@@ -11,26 +17,111 @@ import (
 )
 
 const (
-	usagePort   = "set the https reverse proxy port"
-	usageTarget = "set https reverse proxy target (your local service)"
+	usagePort            = "set the https reverse proxy port"
+	usageTarget          = "set https reverse proxy target (your local service)"
+	usageRoute           = "add a https reverse proxy route in the form [host][/prefix]=target (repeatable)"
+	usageUpstreamTimeout = "set the https reverse proxy upstream dial and response header timeout"
+	usageIdleTimeout     = "set the https reverse proxy keep-alive idle timeout"
+	usageWriteTimeout    = "set the https reverse proxy response write timeout (0 disables it)"
+	usageKeyStoreFrom    = "key store to migrate from (file, encrypted or relay)"
+	usageKeyStoreTo      = "key store to migrate to (file, encrypted or relay)"
+	usageKeyStoreDelete  = "delete the key from the source key store after migrating"
+	usageShutdownTimeout = "time to wait for active sessions to finish on shutdown"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
+const (
+	exitCodeFailure = 1
+	exitCodeConfig  = 2
+)
+
+// errConfig marks errors caused by an invalid configuration.
+var errConfig = errors.New("invalid configuration")
+
 func main() {
-	rootCmd := &cobra.Command{Use: "nws"}
-	exitCmd := &cobra.Command{Use: "exit", Run: startExitNode}
+	rootCmd := &cobra.Command{Use: "nws", SilenceUsage: true, SilenceErrors: true}
+	rootCmd.PersistentFlags().Duration("shutdown-timeout", defaultShutdownTimeout, usageShutdownTimeout)
+	exitCmd := &cobra.Command{Use: "exit", RunE: startExitNode}
 	var httpsPort int32
 	var httpTarget string
 	exitCmd.Flags().Int32VarP(&httpsPort, "port", "p", 0, usagePort)
 	exitCmd.Flags().StringVarP(&httpTarget, "target", "t", "", usageTarget)
-	entryCmd := &cobra.Command{Use: "entry", Run: startEntryNode}
+	exitCmd.Flags().StringArray("route", nil, usageRoute)
+	exitCmd.Flags().Duration("upstream-timeout", 0, usageUpstreamTimeout)
+	exitCmd.Flags().Duration("idle-timeout", 0, usageIdleTimeout)
+	exitCmd.Flags().Duration("write-timeout", 0, usageWriteTimeout)
+	migrateKeyCmd := &cobra.Command{
+		Use:   "migrate-key",
+		Short: "migrate the tls private key of the exit node between key stores",
+		RunE:  migrateKey,
+	}
+	migrateKeyCmd.Flags().String("from", exit.KeyStoreRelay, usageKeyStoreFrom)
+	migrateKeyCmd.Flags().String("to", exit.KeyStoreFile, usageKeyStoreTo)
+	migrateKeyCmd.Flags().Bool("delete", false, usageKeyStoreDelete)
+	exitCmd.AddCommand(migrateKeyCmd)
+	entryCmd := &cobra.Command{Use: "entry", RunE: startEntryNode}
 	rootCmd.AddCommand(exitCmd)
 	rootCmd.AddCommand(entryCmd)
-	err := rootCmd.Execute()
+	rootCmd.AddCommand(newAddressBookCmd())
+	rootCmd.AddCommand(newForwardCmd())
+	rootCmd.AddCommand(newExposeCmd())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
 	if err != nil {
-		panic(err)
+		slog.Error("nws failed", "error", err)
+		os.Exit(exitCode(err))
 	}
 }
 
+// shutdown calls the given shutdown function with the configured shutdown timeout.
+func shutdown(cmd *cobra.Command, shutdownFunc func(ctx context.Context) error) error {
+	timeout, err := cmd.Flags().GetDuration("shutdown-timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get shutdown timeout: %w", err)
+	}
+	slog.Info("shutting down", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err = shutdownFunc(ctx); err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
+	return nil
+}
+
+// serve runs serveFunc until it returns or the command context is canceled and shuts down gracefully afterwards.
+func serve(cmd *cobra.Command, serveFunc func() error, shutdownFunc func(ctx context.Context) error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serveFunc()
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+	case <-cmd.Context().Done():
+		slog.Info("received shutdown signal")
+	}
+	return errors.Join(err, shutdown(cmd, shutdownFunc))
+}
+
+// exitCode returns the process exit code for the given error.
+func exitCode(err error) int {
+	if errors.Is(err, errConfig) {
+		return exitCodeConfig
+	}
+	return exitCodeFailure
+}
+
+// loadConfig loads the configuration and marks failures as configuration errors.
+func loadConfig[T any]() (*T, error) {
+	cfg, err := config.LoadConfig[T]()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errConfig, err)
+	}
+	return cfg, nil
+}
+
 // updateConfigFlag updates the configuration with the provided flags.
 func updateConfigFlag(cmd *cobra.Command, cfg *config.ExitConfig) error {
 	httpsPort, err := cmd.Flags().GetInt32("port")
@@ -43,15 +134,36 @@ func updateConfigFlag(cmd *cobra.Command, cfg *config.ExitConfig) error {
 	}
 	cfg.HttpsPort = httpsPort
 	cfg.HttpsTarget = httpTarget
+	if cmd.Flags().Changed("route") {
+		routes, err := cmd.Flags().GetStringArray("route")
+		if err != nil {
+			return fmt.Errorf("failed to get https routes: %w", err)
+		}
+		cfg.HttpsRoutes = append(cfg.HttpsRoutes, routes...)
+	}
+	timeouts := map[string]*time.Duration{
+		"upstream-timeout": &cfg.HttpsUpstreamTimeout,
+		"idle-timeout":     &cfg.HttpsIdleTimeout,
+		"write-timeout":    &cfg.HttpsWriteTimeout,
+	}
+	for name, timeout := range timeouts {
+		if !cmd.Flags().Changed(name) {
+			continue
+		}
+		*timeout, err = cmd.Flags().GetDuration(name)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", name, err)
+		}
+	}
 	return nil
 }
 
-func startExitNode(cmd *cobra.Command, _ []string) {
+func startExitNode(cmd *cobra.Command, _ []string) error {
 	slog.Info("Starting exit node")
 	// load the configuration
-	cfg, err := config.LoadConfig[config.ExitConfig]()
+	cfg, err := loadConfig[config.ExitConfig]()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	if len(cfg.NostrRelays) == 0 {
 		slog.Info("No relays provided, using default relays")
@@ -59,24 +171,50 @@ func startExitNode(cmd *cobra.Command, _ []string) {
 	}
 	err = updateConfigFlag(cmd, cfg)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+	// the exit node is stopped by Shutdown, so its context must outlive the signal context
+	ctx := context.WithoutCancel(cmd.Context())
+	exitNode, err := exit.New(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to start exit node: %w", err)
+	}
+	return serve(cmd, func() error { return exitNode.ListenAndServe(ctx) }, exitNode.Shutdown)
+}
+
+func migrateKey(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadConfig[config.ExitConfig]()
+	if err != nil {
+		return err
 	}
-	ctx := cmd.Context()
-	exitNode := exit.New(ctx, cfg)
-	exitNode.ListenAndServe(ctx)
+	if len(cfg.NostrRelays) == 0 {
+		cfg.NostrRelays = config.DefaultRelays
+	}
+	from, err := cmd.Flags().GetString("from")
+	if err != nil {
+		return fmt.Errorf("failed to get source key store: %w", err)
+	}
+	to, err := cmd.Flags().GetString("to")
+	if err != nil {
+		return fmt.Errorf("failed to get destination key store: %w", err)
+	}
+	deleteSource, err := cmd.Flags().GetBool("delete")
+	if err != nil {
+		return fmt.Errorf("failed to get delete flag: %w", err)
+	}
+	return exit.MigrateKeyStore(cmd.Context(), cfg, from, to, deleteSource)
 }
 
-func startEntryNode(cmd *cobra.Command, _ []string) {
+func startEntryNode(cmd *cobra.Command, _ []string) error {
 	slog.Info("Starting entry node")
-	cfg, err := config.LoadConfig[config.EntryConfig]()
+	cfg, err := loadConfig[config.EntryConfig]()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	// create a new gw server
-	socksProxy := proxy.New(cmd.Context(), cfg)
-	err = socksProxy.Start()
+	socksProxy, err := proxy.New(context.WithoutCancel(cmd.Context()), cfg)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to start entry node: %w", err)
 	}
-
+	return serve(cmd, socksProxy.Start, socksProxy.Shutdown)
 }
@@ -0,0 +1,168 @@
+// Package codec parses and encodes NWS addresses. An address identifies an exit node by its public key and
+// relays, optionally with a port, in one of three forms: a ".nostr" domain, an npub or an nprofile.
+package codec
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Format is the textual form of an Address.
+type Format int
+
+const (
+	// FormatDomain is the version 2 ".nostr" domain, see EncodeNostrDomain.
+	FormatDomain Format = iota
+	// FormatNpub is the NIP-19 npub of the public key. It has no relays.
+	FormatNpub
+	// FormatNprofile is the NIP-19 nprofile of the public key and relays.
+	FormatNprofile
+)
+
+const (
+	npubPrefix     = "npub1"
+	nprofilePrefix = "nprofile1"
+	// maxRelayLength is the longest relay url a NIP-19 TLV entry can hold.
+	maxRelayLength = 255
+)
+
+var (
+	// ErrNoNostrAddress is returned by ParseAddress for clearnet domains and ip addresses.
+	ErrNoNostrAddress = errors.New("not a nostr address")
+	ErrInvalidAddress = errors.New("invalid nostr address")
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatDomain:
+		return "domain"
+	case FormatNpub:
+		return "npub"
+	case FormatNprofile:
+		return "nprofile"
+	default:
+		return "Format(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// Address is the exit node of a destination.
+type Address struct {
+	// PublicKey is the hex encoded public key of the exit node.
+	PublicKey string
+	// Relays of the exit node, if known.
+	Relays []string
+	// Port is the destination port, or 0 if the address has none.
+	Port uint16
+}
+
+// IsAddress reports whether the host looks like a nostr address, without validating it.
+func IsAddress(host string) bool {
+	host = strings.ToLower(host)
+	return strings.HasPrefix(host, npubPrefix) || strings.HasPrefix(host, nprofilePrefix) ||
+		strings.HasSuffix(strings.TrimSuffix(host, "."), "."+NostrTLD)
+}
+
+// ParseAddress parses an npub, nprofile or ".nostr" domain (version 1 or 2), followed by an optional port.
+// It returns ErrNoNostrAddress if the host is none of them.
+func ParseAddress(s string) (Address, error) {
+	host, port, hasPort := strings.Cut(s, ":")
+	if !IsAddress(host) {
+		return Address{}, fmt.Errorf("%w: %q", ErrNoNostrAddress, s)
+	}
+	var address Address
+	if hasPort {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return Address{}, fmt.Errorf("%w %q: invalid port", ErrInvalidAddress, s)
+		}
+		address.Port = uint16(p)
+	}
+	var err error
+	switch lower := strings.ToLower(host); {
+	case strings.HasPrefix(lower, npubPrefix), strings.HasPrefix(lower, nprofilePrefix):
+		address.PublicKey, address.Relays, err = decodeEntity(lower)
+	default:
+		address.PublicKey, address.Relays, err = DecodeNostrDomain(host)
+	}
+	if err != nil {
+		return Address{}, fmt.Errorf("%w %q: %w", ErrInvalidAddress, s, err)
+	}
+	return address, nil
+}
+
+// decodeEntity decodes an npub or nprofile.
+func decodeEntity(entity string) (publicKey string, relays []string, err error) {
+	// nip19 panics on truncated nprofile entries
+	defer func() {
+		if recover() != nil {
+			err = errors.New("malformed nip-19 entity")
+		}
+	}()
+	prefix, value, err := nip19.Decode(entity)
+	if err != nil {
+		return "", nil, err
+	}
+	switch prefix {
+	case "npub":
+		publicKey = value.(string)
+	case "nprofile":
+		profile := value.(nostr.ProfilePointer)
+		publicKey, relays = profile.PublicKey, profile.Relays
+	default:
+		return "", nil, fmt.Errorf("unexpected nip-19 prefix %q", prefix)
+	}
+	if err = validatePublicKey(publicKey); err != nil {
+		return "", nil, err
+	}
+	return publicKey, relays, nil
+}
+
+func validatePublicKey(publicKey string) error {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != publicKeyLength {
+		return fmt.Errorf("invalid public key %q", publicKey)
+	}
+	if _, err = schnorr.ParsePubKey(key); err != nil {
+		return fmt.Errorf("invalid public key %q: %w", publicKey, err)
+	}
+	return nil
+}
+
+// Encode returns the address in the format, followed by the port if it is set.
+// FormatNpub has no relays, they are omitted.
+func (a Address) Encode(format Format) (string, error) {
+	if err := validatePublicKey(a.PublicKey); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAddress, err)
+	}
+	var host string
+	var err error
+	switch format {
+	case FormatDomain:
+		host, err = EncodeNostrDomain(a.PublicKey, a.Relays)
+	case FormatNpub:
+		host, err = nip19.EncodePublicKey(a.PublicKey)
+	case FormatNprofile:
+		for _, relay := range a.Relays {
+			if len(relay) > maxRelayLength {
+				return "", fmt.Errorf("%w: relay url exceeds %d bytes", ErrInvalidAddress, maxRelayLength)
+			}
+		}
+		host, err = nip19.EncodeProfile(a.PublicKey, a.Relays)
+	default:
+		return "", fmt.Errorf("%w: unknown format %v", ErrInvalidAddress, format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAddress, err)
+	}
+	if a.Port != 0 {
+		host += ":" + strconv.Itoa(int(a.Port))
+	}
+	return host, nil
+}
@@ -0,0 +1,133 @@
+package codec_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestAddressRoundTrip(t *testing.T) {
+	t.Parallel()
+	addresses := []codec.Address{
+		{PublicKey: domainTestPublicKey},
+		{PublicKey: domainTestPublicKey, Port: 443},
+		{PublicKey: domainTestPublicKey, Relays: []string{"wss://relay.8333.space", "ws://127.0.0.1:7777"}},
+		{PublicKey: domainTestPublicKey, Relays: []string{"wss://relay.damus.io"}, Port: 8080},
+	}
+	for _, address := range addresses {
+		for _, format := range []codec.Format{codec.FormatDomain, codec.FormatNpub, codec.FormatNprofile} {
+			addressCopy, formatCopy := address, format
+			t.Run(formatCopy.String(), func(t *testing.T) {
+				t.Parallel()
+				encoded, err := addressCopy.Encode(formatCopy)
+				if err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+				got, err := codec.ParseAddress(encoded)
+				if err != nil {
+					t.Fatalf("ParseAddress(%s) error = %v", encoded, err)
+				}
+				want := addressCopy
+				if formatCopy == codec.FormatNpub {
+					want.Relays = nil
+				}
+				if len(want.Relays) == 0 {
+					want.Relays, got.Relays = nil, nil
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("ParseAddress(%s) = %+v, want %+v", encoded, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	t.Parallel()
+	npub, _ := nip19.EncodePublicKey(domainTestPublicKey)
+	nprofile, _ := nip19.EncodeProfile(domainTestPublicKey, []string{"wss://relay.damus.io"})
+	domain, _ := codec.EncodeNostrDomain(domainTestPublicKey, nil)
+	tests := []struct {
+		name    string
+		address string
+		want    codec.Address
+		wantErr error
+	}{
+		{name: "npub", address: npub, want: codec.Address{PublicKey: domainTestPublicKey}},
+		{name: "npub with port", address: npub + ":80", want: codec.Address{PublicKey: domainTestPublicKey, Port: 80}},
+		{
+			name:    "nprofile",
+			address: nprofile,
+			want:    codec.Address{PublicKey: domainTestPublicKey, Relays: []string{"wss://relay.damus.io"}},
+		},
+		{
+			name:    "domain",
+			address: domain + ":443",
+			want:    codec.Address{PublicKey: domainTestPublicKey, Relays: []string{}, Port: 443},
+		},
+		{name: "clearnet domain", address: "example.com:443", wantErr: codec.ErrNoNostrAddress},
+		{name: "ip address", address: "127.0.0.1", wantErr: codec.ErrNoNostrAddress},
+		{name: "invalid port", address: npub + ":http", wantErr: codec.ErrInvalidAddress},
+		{name: "port out of range", address: npub + ":65536", wantErr: codec.ErrInvalidAddress},
+		{name: "invalid checksum", address: npub[:len(npub)-1] + "q", wantErr: codec.ErrInvalidAddress},
+		{name: "invalid domain", address: "abc.nostr", wantErr: codec.ErrInvalidAddress},
+	}
+	for _, test := range tests {
+		testCopy := test
+		t.Run(testCopy.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := codec.ParseAddress(testCopy.address)
+			if !errors.Is(err, testCopy.wantErr) {
+				t.Fatalf("ParseAddress() error = %v, want %v", err, testCopy.wantErr)
+			}
+			if !reflect.DeepEqual(got, testCopy.want) {
+				t.Errorf("ParseAddress() = %+v, want %+v", got, testCopy.want)
+			}
+		})
+	}
+}
+
+func FuzzParseAddress(f *testing.F) {
+	npub, _ := nip19.EncodePublicKey(domainTestPublicKey)
+	nprofile, _ := nip19.EncodeProfile(domainTestPublicKey, []string{"wss://relay.damus.io", "ws://127.0.0.1:7777"})
+	domain, _ := codec.EncodeNostrDomain(domainTestPublicKey, []string{"wss://relay.8333.space"})
+	for _, seed := range []string{npub, nprofile + ":443", domain, domain + ":8080", "example.com", "x.nostr", "nprofile1qq"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		address, err := codec.ParseAddress(s)
+		if err != nil {
+			return
+		}
+		for _, format := range []codec.Format{codec.FormatDomain, codec.FormatNpub, codec.FormatNprofile} {
+			encoded, err := address.Encode(format)
+			if err != nil {
+				// relays may exceed the limits of the format
+				if format == codec.FormatNpub {
+					t.Fatalf("Encode(%v) of %+v error = %v", format, address, err)
+				}
+				continue
+			}
+			got, err := codec.ParseAddress(encoded)
+			if err != nil {
+				t.Fatalf("ParseAddress(%s) error = %v", encoded, err)
+			}
+			if got.PublicKey != address.PublicKey || got.Port != address.Port {
+				t.Fatalf("ParseAddress(%s) = %+v, want %+v", encoded, got, address)
+			}
+			switch format {
+			case codec.FormatNprofile:
+				if len(got.Relays)+len(address.Relays) > 0 && !reflect.DeepEqual(got.Relays, address.Relays) {
+					t.Fatalf("ParseAddress(%s) relays = %q, want %q", encoded, got.Relays, address.Relays)
+				}
+			case codec.FormatDomain:
+				if len(got.Relays) != len(address.Relays) {
+					t.Fatalf("ParseAddress(%s) relays = %q, want %q", encoded, got.Relays, address.Relays)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// Nostr domains encode the public key and relay hints of an exit node as labels of the ".nostr" TLD.
+//
+// Version 1 encodes each relay URL as a base32 label, followed by the base32 encoded public key:
+//
+//	<base32(relay)>...<base32(pubkey)>.nostr
+//
+// Relay URLs longer than 39 bytes exceed the 63 character label limit. Version 2 is compact and checksummed:
+//
+//	[y<hint>[.z<hint>...]]...x<base32(pubkey || checksum)>.nostr
+//
+// Relay hints omit the default "wss://" scheme and a trailing slash. Long hints continue in labels prefixed
+// with "z". The checksum covers the public key and all hints. The prefixes x, y and z are not part of the
+// base32 alphabet, so version 2 labels are never valid version 1 labels.
+const (
+	NostrTLD = "nostr"
+
+	maxLabelLength  = 63
+	maxDomainLength = 253
+	publicKeyLength = 32
+	checksumLength  = 3
+
+	keyPrefix          = 'x'
+	hintPrefix         = 'y'
+	continuationPrefix = 'z'
+	defaultRelayScheme = "wss://"
+	checksumDomain     = "nws-domain-v2"
+)
+
+var (
+	ErrInvalidNostrDomain = errors.New("invalid nostr domain")
+	ErrChecksumMismatch   = errors.New("nostr domain checksum mismatch")
+
+	domainEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+)
+
+// EncodeNostrDomain returns the version 2 ".nostr" domain of the hex public key and relays.
+func EncodeNostrDomain(publicKey string, relays []string) (string, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != publicKeyLength {
+		return "", fmt.Errorf("%w: invalid public key %q", ErrInvalidNostrDomain, publicKey)
+	}
+	hints := make([][]byte, 0, len(relays))
+	labels := make([]string, 0, len(relays)+2)
+	for _, relay := range relays {
+		hint := compressRelay(relay)
+		hints = append(hints, hint)
+		labels = append(labels, hintLabels(domainEncoding.EncodeToString(hint))...)
+	}
+	payload := append(key, domainChecksum(key, hints)...)
+	labels = append(labels, string(keyPrefix)+domainEncoding.EncodeToString(payload), NostrTLD)
+	domain := strings.ToLower(strings.Join(labels, "."))
+	if len(domain) > maxDomainLength {
+		return "", fmt.Errorf("%w: relay hints exceed the maximum domain length", ErrInvalidNostrDomain)
+	}
+	return domain, nil
+}
+
+// DecodeNostrDomain returns the hex public key and relays of a version 1 or version 2 ".nostr" domain.
+func DecodeNostrDomain(domain string) (string, []string, error) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	if len(labels) < 2 || labels[len(labels)-1] != NostrTLD {
+		return "", nil, fmt.Errorf("%w %q", ErrInvalidNostrDomain, domain)
+	}
+	labels = labels[:len(labels)-1]
+	keyLabel := labels[len(labels)-1]
+	if keyLabel != "" && keyLabel[0] == keyPrefix {
+		return decodeV2(labels)
+	}
+	return decodeV1(labels)
+}
+
+func decodeV1(labels []string) (string, []string, error) {
+	relays := make([]string, 0, len(labels)-1)
+	for _, label := range labels[:len(labels)-1] {
+		// labels that are not base32 encoded relays are ignored
+		relay, err := domainEncoding.DecodeString(strings.ToUpper(label))
+		if err != nil || len(relay) == 0 {
+			continue
+		}
+		relays = append(relays, string(relay))
+	}
+	key, err := domainEncoding.DecodeString(strings.ToUpper(labels[len(labels)-1]))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrInvalidNostrDomain, err)
+	}
+	publicKey, err := parsePublicKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return publicKey, relays, nil
+}
+
+func decodeV2(labels []string) (string, []string, error) {
+	payload, err := domainEncoding.DecodeString(strings.ToUpper(labels[len(labels)-1][1:]))
+	if err != nil || len(payload) != publicKeyLength+checksumLength {
+		return "", nil, fmt.Errorf("%w: invalid public key label", ErrInvalidNostrDomain)
+	}
+	key, checksum := payload[:publicKeyLength], payload[publicKeyLength:]
+
+	var encodedHints []string
+	for _, label := range labels[:len(labels)-1] {
+		switch {
+		case label == "":
+			return "", nil, fmt.Errorf("%w: empty label", ErrInvalidNostrDomain)
+		case label[0] == hintPrefix:
+			encodedHints = append(encodedHints, label[1:])
+		case label[0] == continuationPrefix && len(encodedHints) > 0:
+			encodedHints[len(encodedHints)-1] += label[1:]
+		default:
+			return "", nil, fmt.Errorf("%w: invalid relay hint label %q", ErrInvalidNostrDomain, label)
+		}
+	}
+	hints := make([][]byte, 0, len(encodedHints))
+	relays := make([]string, 0, len(encodedHints))
+	for _, encoded := range encodedHints {
+		hint, err := domainEncoding.DecodeString(strings.ToUpper(encoded))
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: invalid relay hint: %w", ErrInvalidNostrDomain, err)
+		}
+		hints = append(hints, hint)
+		relays = append(relays, expandRelay(hint))
+	}
+	if !bytes.Equal(checksum, domainChecksum(key, hints)) {
+		return "", nil, ErrChecksumMismatch
+	}
+	publicKey, err := parsePublicKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return publicKey, relays, nil
+}
+
+func parsePublicKey(key []byte) (string, error) {
+	pk, err := schnorr.ParsePubKey(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidNostrDomain, err)
+	}
+	return hex.EncodeToString(schnorr.SerializePubKey(pk)), nil
+}
+
+// hintLabels splits an encoded relay hint into a hint label and continuation labels.
+func hintLabels(encoded string) []string {
+	prefix := hintPrefix
+	var labels []string
+	for {
+		n := min(len(encoded), maxLabelLength-1)
+		labels = append(labels, string(prefix)+encoded[:n])
+		encoded = encoded[n:]
+		if encoded == "" {
+			return labels
+		}
+		prefix = continuationPrefix
+	}
+}
+
+// compressRelay removes the default scheme and a trailing slash of the relay url.
+func compressRelay(relay string) []byte {
+	relay = strings.TrimSuffix(relay, "/")
+	if compressed, ok := strings.CutPrefix(relay, defaultRelayScheme); ok {
+		return []byte(compressed)
+	}
+	return []byte(relay)
+}
+
+// expandRelay adds the default scheme to hints without scheme.
+func expandRelay(hint []byte) string {
+	if bytes.Contains(hint, []byte("://")) {
+		return string(hint)
+	}
+	return defaultRelayScheme + string(hint)
+}
+
+func domainChecksum(key []byte, hints [][]byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(checksumDomain))
+	hash.Write(key)
+	for _, hint := range hints {
+		hash.Write(hint)
+		hash.Write([]byte{0})
+	}
+	return hash.Sum(nil)[:checksumLength]
+}
@@ -0,0 +1,95 @@
+package codec_test
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/protocol"
+)
+
+const domainTestPublicKey = "452ebe58d395b1b196a9b8c82b038b6895cb02b683d0c253a955068dba1facd0"
+
+func TestNostrDomainRoundTrip(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		relays []string
+		want   []string
+	}{
+		{name: "no relays", relays: nil, want: []string{}},
+		{name: "default scheme", relays: []string{"wss://relay.8333.space/"}, want: []string{"wss://relay.8333.space"}},
+		{name: "other scheme", relays: []string{"ws://127.0.0.1:7777"}, want: []string{"ws://127.0.0.1:7777"}},
+		{
+			name:   "long relay url",
+			relays: []string{"wss://a-very-long-relay-host-name.example.com/with/a/path/for/nostr", "wss://relay.damus.io"},
+			want:   []string{"wss://a-very-long-relay-host-name.example.com/with/a/path/for/nostr", "wss://relay.damus.io"},
+		},
+	}
+	for _, test := range tests {
+		testCopy := test
+		t.Run(testCopy.name, func(t *testing.T) {
+			t.Parallel()
+			domain, err := codec.EncodeNostrDomain(domainTestPublicKey, testCopy.relays)
+			if err != nil {
+				t.Fatalf("EncodeNostrDomain() error = %v", err)
+			}
+			if !protocol.IsDomainName(domain) {
+				t.Fatalf("EncodeNostrDomain() = %s is not a valid domain name", domain)
+			}
+			publicKey, relays, err := codec.DecodeNostrDomain(strings.ToUpper(domain))
+			if err != nil {
+				t.Fatalf("DecodeNostrDomain() error = %v", err)
+			}
+			if publicKey != domainTestPublicKey || !reflect.DeepEqual(relays, testCopy.want) {
+				t.Errorf("DecodeNostrDomain() = %s, %v, want %s, %v", publicKey, relays, domainTestPublicKey, testCopy.want)
+			}
+		})
+	}
+}
+
+func TestDecodeNostrDomainV1(t *testing.T) {
+	t.Parallel()
+	encoding := base32.HexEncoding.WithPadding(base32.NoPadding)
+	key, _ := hex.DecodeString(domainTestPublicKey)
+	domain := strings.ToLower(encoding.EncodeToString([]byte("wss://relay.8333.space")) + "." +
+		encoding.EncodeToString(key) + ".nostr")
+	publicKey, relays, err := codec.DecodeNostrDomain(domain)
+	if err != nil {
+		t.Fatalf("DecodeNostrDomain() error = %v", err)
+	}
+	if publicKey != domainTestPublicKey || !reflect.DeepEqual(relays, []string{"wss://relay.8333.space"}) {
+		t.Errorf("DecodeNostrDomain() = %s, %v", publicKey, relays)
+	}
+}
+
+func TestDecodeNostrDomainChecksum(t *testing.T) {
+	t.Parallel()
+	domain, err := codec.EncodeNostrDomain(domainTestPublicKey, []string{"wss://relay.8333.space"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a typo in the relay hint
+	typo := []byte(domain)
+	typo[3] = map[bool]byte{true: '1', false: '0'}[typo[3] == '0']
+	if _, _, err = codec.DecodeNostrDomain(string(typo)); !errors.Is(err, codec.ErrChecksumMismatch) {
+		t.Errorf("DecodeNostrDomain() error = %v, want %v", err, codec.ErrChecksumMismatch)
+	}
+	// a dropped relay hint
+	withoutHint := domain[strings.Index(domain, ".")+1:]
+	if _, _, err = codec.DecodeNostrDomain(withoutHint); !errors.Is(err, codec.ErrChecksumMismatch) {
+		t.Errorf("DecodeNostrDomain() error = %v, want %v", err, codec.ErrChecksumMismatch)
+	}
+}
+
+func TestEncodeNostrDomainTooLong(t *testing.T) {
+	t.Parallel()
+	relays := []string{strings.Repeat("a", 100), strings.Repeat("b", 100)}
+	if _, err := codec.EncodeNostrDomain(domainTestPublicKey, relays); !errors.Is(err, codec.ErrInvalidNostrDomain) {
+		t.Errorf("EncodeNostrDomain() error = %v, want %v", err, codec.ErrInvalidNostrDomain)
+	}
+}
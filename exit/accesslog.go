@@ -0,0 +1,57 @@
+package exit
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// statusRecorder wraps a http.ResponseWriter to capture the status code and the response size.
+// It implements Unwrap, so http.ResponseController can still flush and hijack the underlying connection.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	upstream string
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the original http.ResponseWriter.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog wraps the handler and writes a structured log entry for every request.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+		slog.Info("https request",
+			slog.String("method", req.Method),
+			slog.String("host", req.Host),
+			slog.String("path", req.URL.Path),
+			slog.String("proto", req.Proto),
+			slog.String("remote", req.RemoteAddr),
+			slog.String("upstream", recorder.upstream),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Duration("latency", time.Since(start)),
+		)
+	})
+}
@@ -0,0 +1,161 @@
+package exit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/protocol"
+	"github.com/asmogo/nws/socks5"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// bindAcceptTimeout limits how long the exit node waits for the inbound connection of a BIND request.
+const bindAcceptTimeout = 2 * time.Minute
+
+// bindSession is a pending or active BIND session.
+type bindSession struct {
+	listener   net.Listener
+	connection *netstr.NostrConnection
+}
+
+// Close closes the listening socket and the Nostr session.
+func (s *bindSession) Close() error {
+	return errors.Join(s.listener.Close(), s.connection.Close())
+}
+
+// handleBind handles a SOCKS5 BIND request of an entry node.
+// It opens a listening socket and reports the bound address to the entry node.
+// The first inbound connection from the requested destination is reported as well and
+// then proxied over the Nostr session.
+func (e *Exit) handleBind(ctx context.Context, msg nostr.IncomingEvent, protocolMessage *protocol.Message) {
+	e.mutexMap.Lock(protocolMessage.Key.String())
+	defer e.mutexMap.Unlock(protocolMessage.Key.String())
+	connection, err := netstr.NewSession(ctx, e.config.NostrPrivateKey, msg, protocolMessage.Key)
+	if err != nil {
+		return
+	}
+	if !e.bindAllowed() {
+		slog.Info("rejecting bind request", "key", protocolMessage.Key)
+		replyAndClose(connection, socks5.RuleFailureReply)
+		return
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(e.config.BindIP, "0"))
+	if err != nil {
+		slog.Error("could not listen for bind request", "error", err)
+		replyAndClose(connection, socks5.ServerFailureReply)
+		return
+	}
+	e.nostrConnectionMap.Store(protocolMessage.Key.String(), connection)
+	session := &bindSession{listener: listener, connection: connection}
+	done := e.trackSession(protocolMessage.Key.String(), session)
+	go func() {
+		defer done()
+		defer session.Close()
+		if err := serveBind(ctx, session, protocolMessage.Destination); err != nil {
+			slog.Error("bind session failed", "key", protocolMessage.Key, "error", err)
+		}
+	}()
+}
+
+// bindAllowed reports whether BIND requests are accepted.
+// Exit nodes that only connect to their backend do not open listening sockets for entry nodes.
+func (e *Exit) bindAllowed() bool {
+	return !e.config.BindDisabled && !e.config.BackendOnly
+}
+
+// serveBind sends the bound address, waits for the inbound connection and proxies it.
+func serveBind(ctx context.Context, session *bindSession, destination string) error {
+	peers, err := allowedPeers(ctx, destination)
+	if err != nil {
+		replyAndClose(session.connection, socks5.ServerFailureReply)
+		return err
+	}
+	bound := advertisedAddr(session.listener.Addr().(*net.TCPAddr), peers)
+	if err = socks5.SendReply(session.connection, socks5.SuccessReply, bound); err != nil {
+		return fmt.Errorf("failed to send bind reply: %w", err)
+	}
+	slog.Info("listening for bind request", "address", bound, "destination", destination)
+	if listener, ok := session.listener.(*net.TCPListener); ok {
+		if err = listener.SetDeadline(time.Now().Add(bindAcceptTimeout)); err != nil {
+			return fmt.Errorf("failed to set accept deadline: %w", err)
+		}
+	}
+	for {
+		peer, err := session.listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				replyAndClose(session.connection, socks5.TTLExpiredReply)
+			}
+			return fmt.Errorf("failed to accept bind connection: %w", err)
+		}
+		remote := peer.RemoteAddr().(*net.TCPAddr)
+		if len(peers) > 0 && !slices.ContainsFunc(peers, remote.IP.Equal) {
+			slog.Warn("rejecting bind connection from unexpected peer", "peer", remote)
+			peer.Close()
+			continue
+		}
+		// only a single connection is accepted per bind request
+		session.listener.Close()
+		if err = socks5.SendReply(session.connection, socks5.SuccessReply,
+			&socks5.AddrSpec{IP: remote.IP, Port: remote.Port}); err != nil {
+			peer.Close()
+			return fmt.Errorf("failed to send bind reply: %w", err)
+		}
+		proxySession(peer, session.connection)
+		return nil
+	}
+}
+
+// allowedPeers returns the addresses inbound connections are accepted from.
+// Clients that do not know the peer address yet send an unspecified address, which allows every peer.
+func allowedPeers(ctx context.Context, destination string) ([]net.IP, error) {
+	host, _, err := net.SplitHostPort(destination)
+	if err != nil {
+		host = destination
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsUnspecified() {
+			return nil, nil
+		}
+		return []net.IP{ip}, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bind destination: %w", err)
+	}
+	return ips, nil
+}
+
+// advertisedAddr returns the address reported to the client. If the exit node listens on all interfaces,
+// the local address used to reach the expected peer is reported instead.
+func advertisedAddr(bound *net.TCPAddr, peers []net.IP) *socks5.AddrSpec {
+	addr := &socks5.AddrSpec{IP: bound.IP, Port: bound.Port}
+	if !bound.IP.IsUnspecified() || len(peers) == 0 {
+		return addr
+	}
+	// connecting a udp socket does not send any packets
+	conn, err := net.Dial("udp", net.JoinHostPort(peers[0].String(), "9"))
+	if err != nil {
+		return addr
+	}
+	defer conn.Close()
+	addr.IP = conn.LocalAddr().(*net.UDPAddr).IP
+	return addr
+}
+
+// replyAndClose sends a failure reply to the entry node and closes the session.
+func replyAndClose(connection *netstr.NostrConnection, reply uint8) {
+	if err := socks5.SendReply(connection, reply, nil); err != nil {
+		slog.Error("could not send reply", "error", err)
+	}
+	if err := connection.Close(); err != nil {
+		slog.Error("could not close connection", "error", err)
+	}
+}
@@ -0,0 +1,307 @@
+package exit
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+
+	rsaKeySize           = 2048
+	serialNumberBits     = 128
+	renewalCheckInterval = time.Hour
+	// defaultCertValidity and defaultRenewBefore are used if the configuration does not set them.
+	defaultCertValidity = 2160 * time.Hour
+	defaultRenewBefore  = 720 * time.Hour
+)
+
+var (
+	errUnsupportedKeyType = errors.New("unsupported key type")
+	errNoCertificate      = errors.New("no certificate loaded")
+	errInvalidRenewal     = errors.New("certificates must be renewed before they expire")
+)
+
+// CertificateManager manages the lifecycle of the TLS certificate used by the https reverse proxy.
+// It either loads an operator supplied certificate from disk or generates a self-signed certificate,
+// publishes it as a KindCertificateEvent and renews it before it expires.
+type CertificateManager struct {
+	exit        *Exit
+	keys        KeyStore
+	keyType     string
+	validity    time.Duration
+	renewBefore time.Duration
+	certFile    string
+	keyFile     string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	// modTime is the modification time of the operator supplied certificate file when it was loaded.
+	modTime time.Time
+}
+
+// NewCertificateManager creates a new CertificateManager using the exit node configuration.
+// Private keys of generated certificates are persisted in the given key store.
+// Unset validity and renewal periods default to 2160h and 720h.
+func NewCertificateManager(exit *Exit, keys KeyStore) *CertificateManager {
+	m := &CertificateManager{
+		exit:        exit,
+		keys:        keys,
+		keyType:     exit.config.TLSKeyType,
+		validity:    exit.config.TLSCertValidity,
+		renewBefore: exit.config.TLSRenewBefore,
+		certFile:    exit.config.TLSCertFile,
+		keyFile:     exit.config.TLSKeyFile,
+	}
+	if m.validity <= 0 {
+		m.validity = defaultCertValidity
+	}
+	if m.renewBefore <= 0 {
+		m.renewBefore = defaultRenewBefore
+	}
+	return m
+}
+
+// Load loads the initial certificate.
+// An operator supplied certificate is preferred. Otherwise, the certificate published on the relays is reused
+// together with the private key from the key store, as long as it is not due for renewal.
+// If neither is available, a new certificate is generated and published.
+// Generated certificates must be valid for longer than the renewal period, otherwise they would be renewed
+// on every check.
+func (m *CertificateManager) Load(ctx context.Context) error {
+	if m.fromFile() {
+		return m.loadFromFile(ctx)
+	}
+	if m.renewBefore >= m.validity {
+		return fmt.Errorf("%w: renew before %s, validity %s", errInvalidRenewal, m.renewBefore, m.validity)
+	}
+	cert, err := m.exit.loadCertificate(ctx, m.keys)
+	switch {
+	case errors.Is(err, errNoCertificateEvent) || errors.Is(err, ErrKeyNotFound):
+		slog.Info("no stored certificate found, generating a new one", "error", err)
+	case err != nil:
+		// a corrupt certificate event or key must not prevent the exit node from starting
+		slog.Warn("stored certificate is invalid, generating a new one", "error", err)
+	case !m.dueForRenewal(cert):
+		m.setCertificate(cert)
+		return nil
+	}
+	return m.renew(ctx)
+}
+
+// Run periodically checks the certificate and renews or reloads it when needed.
+// It blocks until the context is canceled.
+func (m *CertificateManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(renewalCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.check(ctx); err != nil {
+				slog.Error("failed to refresh certificate", "error", err)
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current certificate. It is meant to be used as tls.Config.GetCertificate.
+func (m *CertificateManager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.certificate == nil {
+		return nil, errNoCertificate
+	}
+	return m.certificate, nil
+}
+
+// check renews a generated certificate if it is due, or reloads the operator supplied certificate
+// if the file was modified.
+func (m *CertificateManager) check(ctx context.Context) error {
+	if m.fromFile() {
+		info, err := os.Stat(m.certFile)
+		if err != nil {
+			return fmt.Errorf("failed to stat certificate file: %w", err)
+		}
+		m.mu.RLock()
+		modified := info.ModTime().After(m.modTime)
+		m.mu.RUnlock()
+		if !modified {
+			return nil
+		}
+		slog.Info("certificate file changed, reloading", "file", m.certFile)
+		return m.loadFromFile(ctx)
+	}
+	m.mu.RLock()
+	cert := m.certificate
+	m.mu.RUnlock()
+	if cert != nil && !m.dueForRenewal(cert) {
+		return nil
+	}
+	slog.Info("renewing certificate")
+	return m.renew(ctx)
+}
+
+func (m *CertificateManager) fromFile() bool {
+	return m.certFile != "" && m.keyFile != ""
+}
+
+func (m *CertificateManager) dueForRenewal(cert *tls.Certificate) bool {
+	return cert.Leaf == nil || time.Now().Add(m.renewBefore).After(cert.Leaf.NotAfter)
+}
+
+func (m *CertificateManager) setCertificate(cert *tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certificate = cert
+	slog.Info("using certificate",
+		"subject", cert.Leaf.Subject.String(), "notAfter", cert.Leaf.NotAfter, "dns", cert.Leaf.DNSNames)
+}
+
+// loadFromFile loads the operator supplied certificate and publishes it, so entry nodes can pin it.
+func (m *CertificateManager) loadFromFile(ctx context.Context) error {
+	info, err := os.Stat(m.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat certificate file: %w", err)
+	}
+	certPEM, err := os.ReadFile(m.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	keyPEM, err := os.ReadFile(m.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err = m.exit.storeCertificate(ctx, certPEM); err != nil {
+		return fmt.Errorf("failed to publish certificate: %w", err)
+	}
+	m.setCertificate(cert)
+	m.mu.Lock()
+	m.modTime = info.ModTime()
+	m.mu.Unlock()
+	return nil
+}
+
+// renew generates a new self-signed certificate, stores the private key and publishes the certificate.
+func (m *CertificateManager) renew(ctx context.Context) error {
+	domain, err := m.exit.Domain()
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := generateCertificate(domain, m.keyType, m.validity)
+	if err != nil {
+		return err
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err = m.keys.Store(ctx, keyPEM); err != nil {
+		return fmt.Errorf("failed to store private key: %w", err)
+	}
+	if err = m.exit.storeCertificate(ctx, certPEM); err != nil {
+		return fmt.Errorf("failed to publish certificate: %w", err)
+	}
+	m.setCertificate(cert)
+	return nil
+}
+
+// generateCertificate creates a self-signed certificate for the given domain.
+// It returns the PEM encoded certificate and the PEM encoded PKCS #8 private key.
+func generateCertificate(domain, keyType string, validity time.Duration) ([]byte, []byte, error) {
+	signer, err := generateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	notBefore := time.Now()
+	keyUsage := x509.KeyUsageDigitalSignature
+	if keyType == KeyTypeRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"NWS"},
+			CommonName:   domain,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{domain},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, signer.Public(), signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	return certPEM, keyPEM, nil
+}
+
+// generateKey generates a new private key of the given type.
+func generateKey(keyType string) (crypto.Signer, error) {
+	var signer crypto.Signer
+	var err error
+	switch keyType {
+	case KeyTypeECDSA, "":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case KeyTypeRSA:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedKeyType, keyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", keyType, err)
+	}
+	return signer, nil
+}
+
+// parseKeyPair parses a PEM encoded certificate and private key and populates the certificate leaf.
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key pair: %w", err)
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+	}
+	return &cert, nil
+}
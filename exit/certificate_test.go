@@ -0,0 +1,78 @@
+package exit
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/config"
+)
+
+func TestGenerateCertificate(t *testing.T) {
+	const domain = "example.nostr"
+	validity := 24 * time.Hour
+	for _, keyType := range []string{KeyTypeECDSA, KeyTypeEd25519, KeyTypeRSA} {
+		t.Run(keyType, func(t *testing.T) {
+			certPEM, keyPEM, err := generateCertificate(domain, keyType, validity)
+			if err != nil {
+				t.Fatalf("generateCertificate() error = %v", err)
+			}
+			cert, err := parseKeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatalf("parseKeyPair() error = %v", err)
+			}
+			if !cert.Leaf.NotAfter.After(time.Now().Add(validity - time.Minute)) {
+				t.Errorf("certificate expires too early: %s", cert.Leaf.NotAfter)
+			}
+			if err = cert.Leaf.VerifyHostname(domain); err != nil {
+				t.Errorf("VerifyHostname() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateCertificateUnsupportedKeyType(t *testing.T) {
+	if _, _, err := generateCertificate("example.nostr", "dsa", time.Hour); err == nil {
+		t.Fatal("expected error for unsupported key type")
+	}
+}
+
+func TestCertificateManagerDueForRenewal(t *testing.T) {
+	certPEM, keyPEM, err := generateCertificate("example.nostr", KeyTypeECDSA, 48*time.Hour)
+	if err != nil {
+		t.Fatalf("generateCertificate() error = %v", err)
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("parseKeyPair() error = %v", err)
+	}
+	if (&CertificateManager{renewBefore: 24 * time.Hour}).dueForRenewal(cert) {
+		t.Error("certificate should not be due for renewal")
+	}
+	if !(&CertificateManager{renewBefore: 72 * time.Hour}).dueForRenewal(cert) {
+		t.Error("certificate should be due for renewal")
+	}
+	if !(&CertificateManager{}).dueForRenewal(&tls.Certificate{}) {
+		t.Error("certificate without leaf should be due for renewal")
+	}
+}
+
+func TestNewCertificateManagerDefaults(t *testing.T) {
+	m := NewCertificateManager(&Exit{config: &config.ExitConfig{}}, nil)
+	if m.validity != defaultCertValidity || m.renewBefore != defaultRenewBefore {
+		t.Errorf("validity = %s, renew before = %s, want %s, %s",
+			m.validity, m.renewBefore, defaultCertValidity, defaultRenewBefore)
+	}
+}
+
+func TestCertificateManagerLoadInvalidRenewal(t *testing.T) {
+	m := NewCertificateManager(&Exit{config: &config.ExitConfig{
+		TLSCertValidity: 24 * time.Hour,
+		TLSRenewBefore:  720 * time.Hour,
+	}}, nil)
+	if err := m.Load(context.Background()); !errors.Is(err, errInvalidRenewal) {
+		t.Fatalf("Load() error = %v, want %v", err, errInvalidRenewal)
+	}
+}
@@ -1,31 +1,39 @@
 package exit
 
 import (
-	"encoding/base32"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
-	"strings"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/asmogo/nws/codec"
 	"github.com/asmogo/nws/config"
 	"github.com/asmogo/nws/netstr"
 	"github.com/asmogo/nws/protocol"
 	"github.com/asmogo/nws/socks5"
-	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/btcsuite/btcd/btcec/v2/schnorr"
-	"github.com/ekzyis/nip44"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/puzpuzpuz/xsync/v3"
 	"golang.org/x/net/context"
 )
 
+// closeTimeout limits how long Shutdown waits for sessions to send their close messages
+// once the shutdown context is done.
+const closeTimeout = 5 * time.Second
+
 const (
 	startingReverseProxyMessage = "starting exit node with https reverse proxy"
 	generateKeyMessage          = "Generated new private key. Please set your environment using the new key, otherwise your key will be lost." //nolint: lll
 )
 
+// errBackendOnly is returned for destinations other than the backend host if BackendOnly is set.
+var errBackendOnly = errors.New("exit node only connects to its backend")
+
 // Exit represents a structure that holds information related to an exit node.
 type Exit struct {
 	// pool represents a pool of relays and manages the subscription to incoming events from relays.
@@ -42,19 +50,46 @@ type Exit struct {
 	mutexMap *MutexMap
 	// incomingChannel represents a channel used to receive incoming events from relays.
 	incomingChannel chan nostr.IncomingEvent
-	nprofile        string
-	publicKey       string
+	// errorChannel receives errors of background services, like the https reverse proxy.
+	errorChannel chan error
+	nprofile     string
+	publicKey    string
+
+	// ctx is the context of the exit node. Sessions are bound to it and it is canceled on shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// associations holds the udp associations by session key.
+	associations *xsync.MapOf[string, *udpAssociation]
+	// sessions holds the active sessions, so they can be closed and drained on shutdown.
+	sessions     *xsync.MapOf[string, io.Closer]
+	sessionGroup sync.WaitGroup
+	// closing is set once Shutdown was called. New sessions are rejected from then on.
+	closing atomic.Bool
+	// done is closed on shutdown and stops ListenAndServe.
+	done         chan struct{}
+	shutdownOnce sync.Once
+	// announcement is the last published announcement event, which is withdrawn on shutdown.
+	announcement   atomic.Pointer[nostr.Event]
+	announceCancel context.CancelFunc
+	// httpsServer is the https reverse proxy server, if enabled.
+	httpsServer atomic.Pointer[http.Server]
 }
 
-func New(ctx context.Context, exitNodeConfig *config.ExitConfig) *Exit {
+// New creates a new exit node, connects it to the configured relays and subscribes to incoming events.
+// It returns an error if the exit node could not be set up.
+// The exit node runs until the context is canceled or Shutdown is called.
+func New(ctx context.Context, exitNodeConfig *config.ExitConfig) (*Exit, error) {
 	// Generate new private key if needed
 	generatePrivateKeyIfNeeded(exitNodeConfig)
 
+	ctx, cancel := context.WithCancel(ctx)
 	// Create a new exit node
 	exit, err := createExitNode(ctx, exitNodeConfig)
 	if err != nil {
-		panic(err)
+		cancel()
+		return nil, err
 	}
+	exit.ctx, exit.cancel = ctx, cancel
 
 	// Setup reverse proxy if HTTPS port is set
 	setupReverseProxy(ctx, exit, exitNodeConfig)
@@ -62,26 +97,31 @@ func New(ctx context.Context, exitNodeConfig *config.ExitConfig) *Exit {
 	// Add relays to the pool
 	addRelaysToPool(exit, exitNodeConfig.NostrRelays)
 
-	if err := exit.setSubscriptions(ctx); err != nil {
-		panic(err)
+	if err = exit.setSubscriptions(ctx); err != nil {
+		cancel()
+		return nil, err
 	}
 
-	if err := exit.announceExitNode(ctx); err != nil {
+	if err = exit.announceExitNode(ctx); err != nil {
 		slog.Error("failed to announce exit node", "error", err)
 	}
 
-	printExitNodeInfo(exit, exitNodeConfig)
+	if err = printExitNodeInfo(exit, exitNodeConfig); err != nil {
+		cancel()
+		return nil, err
+	}
 
-	return exit
+	return exit, nil
 }
 
-func printExitNodeInfo(exit *Exit, exitNodeConfig *config.ExitConfig) {
+func printExitNodeInfo(exit *Exit, exitNodeConfig *config.ExitConfig) error {
 	// Set up remaining steps for the exit node
-	domain, err := exit.getDomain()
+	domain, err := exit.Domain()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to get domain: %w", err)
 	}
 	slog.Info("created exit node", "profile", exitNodeConfig.NostrRelays, "domain", domain)
+	return nil
 }
 
 func newExit(pool *nostr.SimplePool, pubKey string, profile string) *Exit {
@@ -89,6 +129,10 @@ func newExit(pool *nostr.SimplePool, pubKey string, profile string) *Exit {
 		nostrConnectionMap: xsync.NewMapOf[string, *netstr.NostrConnection](),
 		pool:               pool,
 		mutexMap:           NewMutexMap(),
+		errorChannel:       make(chan error, 1),
+		sessions:           xsync.NewMapOf[string, io.Closer](),
+		associations:       xsync.NewMapOf[string, *udpAssociation](),
+		done:               make(chan struct{}),
 		publicKey:          pubKey,
 		nprofile:           profile,
 	}
@@ -121,6 +165,8 @@ func createExitNode(ctx context.Context, cfg *config.ExitConfig) (*Exit, error)
 	return exit, nil
 }
 
+// setupReverseProxy starts the https reverse proxy if a port is configured.
+// Errors of the reverse proxy are reported to ListenAndServe.
 func setupReverseProxy(ctx context.Context, exit *Exit, cfg *config.ExitConfig) {
 	if cfg.HttpsPort != 0 {
 		cfg.BackendHost = fmt.Sprintf(":%d", cfg.HttpsPort)
@@ -128,7 +174,7 @@ func setupReverseProxy(ctx context.Context, exit *Exit, cfg *config.ExitConfig)
 			slog.Info(startingReverseProxyMessage, "port", cfg.HttpsPort)
 			err := exit.StartReverseProxy(ctx, cfg.HttpsTarget, cfg.HttpsPort)
 			if err != nil {
-				panic(err)
+				exit.errorChannel <- fmt.Errorf("https reverse proxy failed: %w", err)
 			}
 		}(ctx, cfg)
 	}
@@ -146,42 +192,19 @@ func addRelaysToPool(exit *Exit, relays []string) {
 	}
 }
 
-// getDomain returns the domain string used by the Exit node for communication with the Nostr relays.
-// It concatenates the relay URLs using base32 encoding with no padding, separated by dots.
-// The domain is then appended with the base32 encoded public key obtained using the configured Nostr private key.
-// The final domain string is converted to lowercase and returned.
-func (e *Exit) getDomain() (string, error) {
-	var domain string
-	// first lets build the subdomains
-	for _, relayURL := range e.config.NostrRelays {
-		if domain == "" {
-			domain = base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(relayURL))
-		} else {
-			domain = fmt.Sprintf("%s.%s",
-				domain, base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(relayURL)))
-		}
-	}
-	// create base32 encoded public key
-	decoded, err := GetPublicKeyBase32(e.config.NostrPrivateKey)
+// Domain returns the domain string used by the Exit node for communication with the Nostr relays.
+// It is the ".nostr" domain of the public key and relays, see codec.EncodeNostrDomain.
+func (e *Exit) Domain() (string, error) {
+	publicKey, err := nostr.GetPublicKey(e.config.NostrPrivateKey)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to get public key: %w", err)
 	}
-	// use public key as host. add TLD
-	domain = strings.ToLower(fmt.Sprintf("%s.%s.nostr", domain, decoded))
-	return domain, nil
+	return codec.Address{PublicKey: publicKey, Relays: e.config.NostrRelays}.Encode(codec.FormatDomain)
 }
 
-// GetPublicKeyBase32 decodes the private key string from hexadecimal format
-// and returns the base32 encoded public key obtained using the provided private key.
-// The base32 encoding has no padding. If there is an error decoding the private key
-// or generating the public key, an error is returned.
-func GetPublicKeyBase32(sk string) (string, error) {
-	b, err := hex.DecodeString(sk)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode private key: %w", err)
-	}
-	_, pk := btcec.PrivKeyFromBytes(b)
-	return base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(schnorr.SerializePubKey(pk)), nil
+// Profile returns the nprofile of the exit node, which clients use as destination.
+func (e *Exit) Profile() string {
+	return e.nprofile
 }
 
 // setSubscriptions sets up subscriptions for the Exit node to receive incoming events from the specified relays.
@@ -206,23 +229,17 @@ func (e *Exit) setSubscriptions(ctx context.Context) error {
 // It sets up the incoming event channel and starts a goroutine to handle the events.
 // It returns an error if there is any issue with the subscription.
 func (e *Exit) handleSubscription(ctx context.Context, pubKey string, since nostr.Timestamp) error {
-	incomingEventChannel := e.pool.SubMany(ctx, e.config.NostrRelays, nostr.Filters{
-		{
-			Kinds: []int{protocol.KindEphemeralEvent},
-			Since: &since,
-			Tags: nostr.TagMap{
-				"p": []string{pubKey},
-			},
-		},
-	})
+	incomingEventChannel := netstr.Subscribe(ctx, e.pool, e.config.NostrRelays, pubKey, since)
 	e.incomingChannel = incomingEventChannel
 	return nil
 }
 
 // ListenAndServe handles incoming events from the subscription channel.
 // It processes each event by calling the processMessage method, as long as the event is not nil.
-// If the context is canceled (ctx.Done() receives a value), the method returns.
-func (e *Exit) ListenAndServe(ctx context.Context) {
+// If the context is canceled (ctx.Done() receives a value) or Shutdown is called, the method returns nil.
+// If a background service like the https reverse proxy fails, its error is returned.
+// Sessions are bound to the exit node context, so they survive the cancellation of ctx until Shutdown.
+func (e *Exit) ListenAndServe(ctx context.Context) error {
 	for {
 		select {
 		case event := <-e.incomingChannel:
@@ -230,53 +247,151 @@ func (e *Exit) ListenAndServe(ctx context.Context) {
 			if event.Relay == nil {
 				continue
 			}
-			go e.processMessage(ctx, event)
+			go e.processMessage(e.ctx, event)
+		case err := <-e.errorChannel:
+			return err
+		case <-e.done:
+			return nil
 		case <-ctx.Done():
-			return
+			return nil
 		}
 	}
 }
 
+// Shutdown gracefully shuts down the exit node.
+// It stops accepting new sessions, withdraws the exit node announcement and the https reverse proxy
+// and waits for active sessions to drain until the context is done.
+// Sessions still open then are closed, which sends close messages to their peers.
+// Finally, the relay connections are closed.
+func (e *Exit) Shutdown(ctx context.Context) error {
+	e.closing.Store(true)
+	e.shutdownOnce.Do(func() { close(e.done) })
+	slog.Info("shutting down exit node")
+	var errs []error
+	if err := e.withdrawAnnouncement(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to withdraw announcement: %w", err))
+	}
+	if server := e.httpsServer.Load(); server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down https reverse proxy: %w", err))
+		}
+	}
+	if !e.waitForSessions(ctx) {
+		errs = append(errs, fmt.Errorf("sessions did not drain: %w", ctx.Err()))
+		e.sessions.Range(func(key string, session io.Closer) bool {
+			if err := session.Close(); err != nil {
+				slog.Error("could not close session", "key", key, "error", err)
+			}
+			return true
+		})
+		closeCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		e.waitForSessions(closeCtx)
+		cancel()
+	}
+	e.pool.Relays.Range(func(url string, relay *nostr.Relay) bool {
+		if err := relay.Close(); err != nil {
+			slog.Debug("could not close relay", "url", url, "error", err)
+		}
+		return true
+	})
+	e.cancel()
+	return errors.Join(errs...)
+}
+
+// waitForSessions waits until all sessions are done or the context is done.
+// It reports whether all sessions are done.
+func (e *Exit) waitForSessions(ctx context.Context) bool {
+	drained := make(chan struct{})
+	go func() {
+		e.sessionGroup.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// trackSession registers an active session. The returned function must be called once the session ended.
+func (e *Exit) trackSession(key string, session io.Closer) func() {
+	e.sessionGroup.Add(1)
+	e.sessions.Store(key, session)
+	return func() {
+		e.sessions.Delete(key)
+		e.nostrConnectionMap.Delete(key)
+		e.sessionGroup.Done()
+	}
+}
+
+// proxySession proxies data between the two connections until both directions are done.
+func proxySession(a, b net.Conn) {
+	// socks5.Proxy reports two results per direction
+	errCh := make(chan error, 4)
+	go socks5.Proxy(a, b, errCh)
+	go socks5.Proxy(b, a, errCh)
+	for i := 0; i < cap(errCh); i++ {
+		<-errCh
+	}
+}
+
 // processMessage decrypts and unmarshals the incoming event message, and then
 // routes the message to the appropriate handler based on its protocol type.
 func (e *Exit) processMessage(ctx context.Context, msg nostr.IncomingEvent) {
-	// hex decode the target public key
-	privateKeyBytes, targetPublicKeyBytes, err := protocol.GetEncryptionKeys(e.config.NostrPrivateKey, msg.PubKey)
-	if err != nil {
-		slog.Error("could not get encryption keys", "error", err)
-		return
-	}
-	sharedKey, err := nip44.GenerateConversationKey(privateKeyBytes, targetPublicKeyBytes)
+	protocolMessage, err := netstr.DecryptMessage(e.config.NostrPrivateKey, msg)
 	if err != nil {
-		slog.Error("could not compute shared key", "error", err)
+		slog.Error("could not read message", "error", err)
 		return
 	}
-	decodedMessage, err := nip44.Decrypt(sharedKey, msg.Content)
+	requested := protocolMessage.Destination
+	protocolMessage.Destination, err = e.egressDestination(requested)
 	if err != nil {
-		slog.Error("could not decrypt message", "error", err)
+		slog.Error("could not parse destination", "error", err)
 		return
 	}
-	protocolMessage, err := protocol.UnmarshalJSON([]byte(decodedMessage))
-	if err != nil {
-		slog.Error("could not unmarshal message", "error", err)
-		return
+	switch protocolMessage.Type {
+	case protocol.MessageConnect, protocol.MessageConnectReverse, protocol.MessageBind:
+		if e.closing.Load() {
+			slog.Info("rejecting new session during shutdown", "key", protocolMessage.Key)
+			return
+		}
+		switch protocolMessage.Type {
+		case protocol.MessageConnect:
+			e.handleConnect(ctx, msg, protocolMessage)
+		case protocol.MessageConnectReverse:
+			e.handleConnectReverse(protocolMessage)
+		default:
+			e.handleBind(ctx, msg, protocolMessage)
+		}
+	case protocol.MessageDatagram:
+		e.handleDatagram(ctx, msg, protocolMessage, requested)
+	case protocol.MessageClose:
+		if association, ok := e.associations.Load(protocolMessage.Key.String()); ok {
+			association.Close()
+			return
+		}
+		e.handleSocks5ProxyMessage(msg, protocolMessage)
+	case protocol.MessageTypeSocks5:
+		e.handleSocks5ProxyMessage(msg, protocolMessage)
 	}
-	destination, err := protocol.Parse(protocolMessage.Destination)
+}
+
+// egressDestination returns the address the exit node connects to for the requested destination.
+// Nostr destinations are mapped to the backend host. Other destinations are rejected if BackendOnly is set.
+// The same policy applies to tcp and udp.
+func (e *Exit) egressDestination(requested string) (string, error) {
+	destination, err := protocol.Parse(requested)
 	if err != nil {
-		slog.Error("could not parse destination", "error", err)
-		return
+		return "", err
 	}
 	if destination.TLD == "nostr" {
-		protocolMessage.Destination = e.config.BackendHost
+		return e.config.BackendHost, nil
 	}
-	switch protocolMessage.Type {
-	case protocol.MessageConnect:
-		e.handleConnect(ctx, msg, protocolMessage)
-	case protocol.MessageConnectReverse:
-		e.handleConnectReverse(protocolMessage)
-	case protocol.MessageTypeSocks5:
-		e.handleSocks5ProxyMessage(msg, protocolMessage)
+	if e.config.BackendOnly {
+		return "", fmt.Errorf("%w: %s", errBackendOnly, requested)
 	}
+	return requested, nil
 }
 
 // handleConnect handles the connection for the given message and protocol message.
@@ -293,16 +408,10 @@ func (e *Exit) handleConnect(
 ) {
 	e.mutexMap.Lock(protocolMessage.Key.String())
 	defer e.mutexMap.Unlock(protocolMessage.Key.String())
-	receiver, err := nip19.EncodeProfile(msg.PubKey, []string{msg.Relay.String()})
+	connection, err := netstr.NewSession(ctx, e.config.NostrPrivateKey, msg, protocolMessage.Key)
 	if err != nil {
 		return
 	}
-	connection := netstr.NewConnection(
-		ctx,
-		netstr.WithPrivateKey(e.config.NostrPrivateKey),
-		netstr.WithDst(receiver),
-		netstr.WithUUID(protocolMessage.Key),
-	)
 
 	var dst net.Conn
 	dst, err = net.Dial("tcp", protocolMessage.Destination)
@@ -317,8 +426,11 @@ func (e *Exit) handleConnect(
 
 	e.nostrConnectionMap.Store(protocolMessage.Key.String(), connection)
 	slog.Info("connected to backend", "key", protocolMessage.Key)
-	go socks5.Proxy(dst, connection, nil)
-	go socks5.Proxy(connection, dst, nil)
+	done := e.trackSession(protocolMessage.Key.String(), connection)
+	go func() {
+		defer done()
+		proxySession(dst, connection)
+	}()
 }
 
 func (e *Exit) handleConnectReverse(protocolMessage *protocol.Message) {
@@ -352,11 +464,14 @@ func (e *Exit) handleConnectReverse(protocolMessage *protocol.Message) {
 		return
 	}
 	slog.Info("connected to entry", "key", protocolMessage.Key)
-	go socks5.Proxy(dst, connection, nil)
-	go socks5.Proxy(connection, dst, nil)
+	done := e.trackSession(protocolMessage.Key.String(), connection)
+	go func() {
+		defer done()
+		proxySession(dst, connection)
+	}()
 }
 
-// handleSocks5ProxyMessage handles the SOCKS5 proxy message by writing it to the destination connection.
+// handleSocks5ProxyMessage handles the SOCKS5 proxy and close messages by writing them to the destination connection.
 // If the destination connection does not exist, the function returns without doing anything.
 //
 // Parameters:
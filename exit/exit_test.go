@@ -0,0 +1,144 @@
+package exit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+func TestEgressDestination(t *testing.T) {
+	domain := "x8knbsm6jimor35l9n342m0sbd2asm0lmgf8c4kt9ak38regvlj8fn1t1.nostr:443"
+	tests := []struct {
+		requested   string
+		backendOnly bool
+		want        string
+		wantErr     error
+	}{
+		{requested: domain, want: "localhost:8080"},
+		{requested: domain, backendOnly: true, want: "localhost:8080"},
+		{requested: "example.com:443", want: "example.com:443"},
+		{requested: "example.com:443", backendOnly: true, wantErr: errBackendOnly},
+	}
+	for _, tt := range tests {
+		e := &Exit{config: &config.ExitConfig{BackendHost: "localhost:8080", BackendOnly: tt.backendOnly}}
+		got, err := e.egressDestination(tt.requested)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("egressDestination(%s) with backend only %v = %s, %v, want %s, %v",
+				tt.requested, tt.backendOnly, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBindAllowed(t *testing.T) {
+	tests := []struct {
+		bindDisabled bool
+		backendOnly  bool
+		want         bool
+	}{
+		{want: true},
+		{bindDisabled: true},
+		{backendOnly: true},
+	}
+	for _, tt := range tests {
+		e := &Exit{config: &config.ExitConfig{BindDisabled: tt.bindDisabled, BackendOnly: tt.backendOnly}}
+		if got := e.bindAllowed(); got != tt.want {
+			t.Errorf("bindAllowed() with bind disabled %v and backend only %v = %v, want %v",
+				tt.bindDisabled, tt.backendOnly, got, tt.want)
+		}
+	}
+}
+
+// testSession is a session that records whether it was closed.
+type testSession struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *testSession) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func newTestExit(t *testing.T) *Exit {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	e := newExit(nostr.NewSimplePool(ctx), "", "")
+	e.config = &config.ExitConfig{}
+	e.ctx, e.cancel = ctx, cancel
+	return e
+}
+
+// startTestSession tracks a session that ends after the duration or once it is closed.
+func startTestSession(e *Exit, key string, duration time.Duration) *testSession {
+	session := &testSession{closed: make(chan struct{})}
+	done := e.trackSession(key, session)
+	go func() {
+		defer done()
+		select {
+		case <-time.After(duration):
+		case <-session.closed:
+		}
+	}()
+	return session
+}
+
+func TestShutdownDrainsSessions(t *testing.T) {
+	e := newTestExit(t)
+	session := startTestSession(e, "session", 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	select {
+	case <-session.closed:
+		t.Fatal("session was closed instead of drained")
+	default:
+	}
+}
+
+func TestShutdownClosesRemainingSessions(t *testing.T) {
+	e := newTestExit(t)
+	session := startTestSession(e, "session", time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-session.closed:
+	default:
+		t.Fatal("remaining session was not closed")
+	}
+	if _, ok := e.sessions.Load("session"); ok {
+		t.Fatal("closed session is still tracked")
+	}
+}
+
+func TestAssociationResolvesOnce(t *testing.T) {
+	association := &udpAssociation{
+		origins: xsync.NewMapOf[string, string](),
+		remotes: xsync.NewMapOf[string, *net.UDPAddr](),
+	}
+	first, err := association.resolve("127.0.0.1:53", "dns.nostr:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := association.resolve("127.0.0.1:53", "dns.nostr:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("destination was resolved again")
+	}
+	if origin, _ := association.origins.Load(first.String()); origin != "dns.nostr:53" {
+		t.Fatalf("origin = %q, want dns.nostr:53", origin)
+	}
+}
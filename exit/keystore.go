@@ -0,0 +1,289 @@
+package exit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/protocol"
+	"github.com/ekzyis/nip44"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	KeyStoreFile      = "file"
+	KeyStoreEncrypted = "encrypted"
+	KeyStoreRelay     = "relay"
+
+	keyFileMode      = 0600
+	keyDirectoryMode = 0700
+	defaultKeyDir    = "keys"
+)
+
+var (
+	// ErrKeyNotFound is returned by a KeyStore if no key was stored yet.
+	ErrKeyNotFound         = errors.New("tls private key not found")
+	errUnsupportedKeyStore = errors.New("unsupported key store")
+)
+
+// KeyStore stores the private key of the exit node TLS certificate.
+type KeyStore interface {
+	// Load returns the PEM encoded private key or ErrKeyNotFound.
+	Load(ctx context.Context) ([]byte, error)
+	// Store persists the PEM encoded private key.
+	Store(ctx context.Context, keyPEM []byte) error
+	// Delete removes the stored private key.
+	Delete(ctx context.Context) error
+}
+
+// NewKeyStore creates the KeyStore of the given type for the exit node.
+// Publishing the key to relays is only done if explicitly configured.
+func NewKeyStore(exit *Exit, storeType string) (KeyStore, error) {
+	switch storeType {
+	case KeyStoreFile, "":
+		path := exit.config.TLSKeyStorePath
+		if path == "" {
+			path = fmt.Sprintf("%s.key", exit.publicKey)
+		}
+		return &FileKeyStore{path: path}, nil
+	case KeyStoreEncrypted:
+		dir := exit.config.TLSKeyStorePath
+		if dir == "" {
+			dir = defaultKeyDir
+		}
+		return &EncryptedKeyStore{
+			path:       filepath.Join(dir, fmt.Sprintf("%s.key.enc", exit.publicKey)),
+			privateKey: exit.config.NostrPrivateKey,
+			publicKey:  exit.publicKey,
+		}, nil
+	case KeyStoreRelay:
+		return &RelayKeyStore{exit: exit}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedKeyStore, storeType)
+	}
+}
+
+// MigrateKey copies the private key from one key store to another.
+// If deleteSource is set, the key is removed from the source store afterwards.
+func MigrateKey(ctx context.Context, from, to KeyStore, deleteSource bool) error {
+	keyPEM, err := from.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load key from source: %w", err)
+	}
+	if err = to.Store(ctx, keyPEM); err != nil {
+		return fmt.Errorf("failed to store key in destination: %w", err)
+	}
+	if deleteSource {
+		if err = from.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete key from source: %w", err)
+		}
+	}
+	return nil
+}
+
+// MigrateKeyStore migrates the TLS private key of the configured exit node between key store types.
+func MigrateKeyStore(ctx context.Context, cfg *config.ExitConfig, from, to string, deleteSource bool) error {
+	exit, err := createExitNode(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	addRelaysToPool(exit, cfg.NostrRelays)
+	source, err := NewKeyStore(exit, from)
+	if err != nil {
+		return err
+	}
+	destination, err := NewKeyStore(exit, to)
+	if err != nil {
+		return err
+	}
+	if err = MigrateKey(ctx, source, destination, deleteSource); err != nil {
+		return err
+	}
+	slog.Info("migrated tls private key", "from", from, "to", to)
+	return nil
+}
+
+// FileKeyStore stores the private key as a plain PEM file, readable only by the owner.
+type FileKeyStore struct {
+	path string
+}
+
+func (s *FileKeyStore) Load(_ context.Context) ([]byte, error) {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key file: %w", err)
+	}
+	if info.Mode().Perm() != keyFileMode {
+		slog.Warn("fixing permissions of key file", "file", s.path, "mode", info.Mode().Perm())
+		if err = os.Chmod(s.path, keyFileMode); err != nil {
+			return nil, fmt.Errorf("failed to change key file permissions: %w", err)
+		}
+	}
+	keyPEM, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return keyPEM, nil
+}
+
+func (s *FileKeyStore) Store(_ context.Context, keyPEM []byte) error {
+	return writeKeyFile(s.path, keyPEM)
+}
+
+func (s *FileKeyStore) Delete(_ context.Context) error {
+	return removeKeyFile(s.path)
+}
+
+// EncryptedKeyStore stores the private key in a directory, encrypted with a key derived from the
+// Nostr private key of the exit node.
+type EncryptedKeyStore struct {
+	path       string
+	privateKey string
+	publicKey  string
+}
+
+func (s *EncryptedKeyStore) Load(_ context.Context) ([]byte, error) {
+	encrypted, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	sharedKey, err := s.conversationKey()
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := nip44.Decrypt(sharedKey, string(encrypted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key file: %w", err)
+	}
+	return []byte(decrypted), nil
+}
+
+func (s *EncryptedKeyStore) Store(_ context.Context, keyPEM []byte) error {
+	sharedKey, err := s.conversationKey()
+	if err != nil {
+		return err
+	}
+	encrypted, err := nip44.Encrypt(sharedKey, string(keyPEM), &nip44.EncryptOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), keyDirectoryMode); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	return writeKeyFile(s.path, []byte(encrypted))
+}
+
+func (s *EncryptedKeyStore) Delete(_ context.Context) error {
+	return removeKeyFile(s.path)
+}
+
+func (s *EncryptedKeyStore) conversationKey() ([]byte, error) {
+	privateKeyBytes, publicKeyBytes, err := protocol.GetEncryptionKeys(s.privateKey, s.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	sharedKey, err := nip44.GenerateConversationKey(privateKeyBytes, publicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared key: %w", err)
+	}
+	return sharedKey, nil
+}
+
+// RelayKeyStore publishes the encrypted private key as KindPrivateKeyEvent to the configured relays.
+// It is meant as an explicit opt-in backup, because the key is stored on public relays.
+type RelayKeyStore struct {
+	exit *Exit
+}
+
+func (s *RelayKeyStore) Load(ctx context.Context) ([]byte, error) {
+	privateKeyEvent := s.query(ctx)
+	if privateKeyEvent == nil {
+		return nil, ErrKeyNotFound
+	}
+	privateKeyBytes, targetPublicKeyBytes, err := protocol.GetEncryptionKeys(
+		s.exit.config.NostrPrivateKey, privateKeyEvent.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	sharedKey, err := nip44.GenerateConversationKey(privateKeyBytes, targetPublicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared key: %w", err)
+	}
+	decodedMessage, err := nip44.Decrypt(sharedKey, privateKeyEvent.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+	message, err := protocol.UnmarshalJSON([]byte(decodedMessage))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return message.Data, nil
+}
+
+func (s *RelayKeyStore) Store(ctx context.Context, keyPEM []byte) error {
+	signer, err := protocol.NewEventSigner(s.exit.config.NostrPrivateKey)
+	if err != nil {
+		return err
+	}
+	event, err := signer.CreateSignedEvent(s.exit.publicKey, protocol.KindPrivateKeyEvent, nostr.Tags{
+		nostr.Tag{"p", s.exit.publicKey},
+	}, protocol.WithData(keyPEM))
+	if err != nil {
+		return err
+	}
+	return s.exit.publish(ctx, event)
+}
+
+// Delete requests the deletion of every private key event found on any of the configured relays.
+// The deletion also references the address of the key event, so relays holding a version that was not found
+// delete it as well.
+func (s *RelayKeyStore) Delete(ctx context.Context) error {
+	var events []*nostr.Event
+	for privateKeyEvent := range s.exit.pool.SubManyEose(ctx, s.exit.config.NostrRelays, nostr.Filters{s.filter()}) {
+		events = append(events, privateKeyEvent.Event)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return s.exit.deleteEvents(ctx, events)
+}
+
+func (s *RelayKeyStore) query(ctx context.Context) *nostr.IncomingEvent {
+	return s.exit.pool.QuerySingle(ctx, s.exit.config.NostrRelays, s.filter())
+}
+
+func (s *RelayKeyStore) filter() nostr.Filter {
+	return nostr.Filter{
+		Authors: []string{s.exit.publicKey},
+		Kinds:   []int{protocol.KindPrivateKeyEvent},
+		Tags:    nostr.TagMap{"p": []string{s.exit.publicKey}},
+	}
+}
+
+func writeKeyFile(path string, data []byte) error {
+	// os.WriteFile does not change the mode of existing files
+	if err := os.WriteFile(path, data, keyFileMode); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := os.Chmod(path, keyFileMode); err != nil {
+		return fmt.Errorf("failed to change key file permissions: %w", err)
+	}
+	return nil
+}
+
+func removeKeyFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove key file: %w", err)
+	}
+	return nil
+}
@@ -20,6 +20,7 @@ func (e *Exit) announceExitNode(ctx context.Context) error {
 	if !e.config.Public {
 		return errNoPublicKey
 	}
+	ctx, e.announceCancel = context.WithCancel(ctx)
 	go func() {
 		for {
 			event := nostr.Event{
@@ -43,35 +44,83 @@ func (e *Exit) announceExitNode(ctx context.Context) error {
 					// do not return here, try to publish the event to other relays
 				}
 			}
-			time.Sleep(time.Second * ten)
+			e.announcement.Store(&event)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second * ten):
+			}
 		}
 	}()
 	return nil
 }
 
+// withdrawAnnouncement stops announcing the exit node and deletes the last announcement,
+// so entry nodes stop selecting this exit node.
+func (e *Exit) withdrawAnnouncement(ctx context.Context) error {
+	if e.announceCancel == nil {
+		return nil
+	}
+	e.announceCancel()
+	event := e.announcement.Load()
+	if event == nil {
+		return nil
+	}
+	return e.DeleteEvent(ctx, event)
+}
+
+// DeleteEvent requests the deletion of the event from all configured relays.
 func (e *Exit) DeleteEvent(ctx context.Context, event *nostr.Event) error {
-	for _, responseRelay := range e.config.NostrRelays {
-		var relay *nostr.Relay
-		relay, err := e.pool.EnsureRelay(responseRelay)
+	return e.deleteEvents(ctx, []*nostr.Event{event})
+}
+
+// deleteEvents publishes a single deletion request for the events to all configured relays.
+// Addressable events are referenced by their address as well, so relays delete every version of them.
+// All relays are asked, even if some of them fail, and their errors are joined.
+func (e *Exit) deleteEvents(ctx context.Context, events []*nostr.Event) error {
+	deletion := nostr.Event{
+		CreatedAt: nostr.Now(),
+		PubKey:    e.publicKey,
+		Kind:      nostr.KindDeletion,
+		Tags:      deletionTags(events),
+	}
+	if err := deletion.Sign(e.config.NostrPrivateKey); err != nil {
+		return fmt.Errorf("failed to sign event: %w", err)
+	}
+	var errs []error
+	for _, url := range e.config.NostrRelays {
+		relay, err := e.pool.EnsureRelay(url)
 		if err != nil {
-			return fmt.Errorf("failed to ensure relay: %w", err)
+			errs = append(errs, fmt.Errorf("failed to ensure relay %s: %w", url, err))
+			continue
 		}
-		event := nostr.Event{
-			CreatedAt: nostr.Now(),
-			PubKey:    e.publicKey,
-			Kind:      nostr.KindDeletion,
-			Tags: nostr.Tags{
-				nostr.Tag{"e", event.ID},
-			},
+		if err = relay.Publish(ctx, deletion); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish event to %s: %w", url, err))
 		}
-		err = event.Sign(e.config.NostrPrivateKey)
-		if err != nil {
-			return fmt.Errorf("failed to sign event: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+// deletionTags references the events by id and addressable events by their address, too.
+func deletionTags(events []*nostr.Event) nostr.Tags {
+	tags := make(nostr.Tags, 0, len(events))
+	addresses := make(map[string]struct{})
+	for _, event := range events {
+		tags = append(tags, nostr.Tag{"e", event.ID})
+		if !isAddressableKind(event.Kind) {
+			continue
 		}
-		err = relay.Publish(ctx, event)
-		if err != nil {
-			return fmt.Errorf("failed to publish event: %w", err)
+		address := fmt.Sprintf("%d:%s:%s", event.Kind, event.PubKey, event.Tags.GetD())
+		if _, ok := addresses[address]; !ok {
+			addresses[address] = struct{}{}
+			tags = append(tags, nostr.Tag{"a", address})
 		}
 	}
-	return nil
+	return tags
+}
+
+// isAddressableKind reports whether events of the kind are addressable (NIP-01),
+// i.e. relays keep one version per kind, author and d tag.
+func isAddressableKind(kind int) bool {
+	return kind >= 30000 && kind < 40000
 }
@@ -0,0 +1,144 @@
+package exit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const upstreamKeepAlive = 30 * time.Second
+
+var (
+	errInvalidRoute = errors.New("invalid route")
+	errNoRoute      = errors.New("no route configured")
+)
+
+// route maps requests matching a host and a path prefix to an upstream target.
+// An empty host matches every host, the prefix "/" matches every path.
+type route struct {
+	host   string
+	prefix string
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+}
+
+// router dispatches incoming requests of the https reverse proxy to the most specific route.
+type router struct {
+	routes []*route
+}
+
+// parseRoute parses a route specification in the form "[host][/prefix]=target".
+// Examples are "/api=http://api:8080", "app.example.com=http://app:3000"
+// or "app.example.com/ws=http://ws:9000".
+func parseRoute(spec string) (*route, error) {
+	match, target, found := strings.Cut(strings.TrimSpace(spec), "=")
+	if !found || target == "" {
+		return nil, fmt.Errorf("%w: %q", errInvalidRoute, spec)
+	}
+	host, prefix := match, "/"
+	if i := strings.Index(match, "/"); i >= 0 {
+		host, prefix = match[:i], match[i:]
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", errInvalidRoute, spec, err)
+	}
+	if targetURL.Scheme == "" || targetURL.Host == "" {
+		return nil, fmt.Errorf("%w: %q: target must be an absolute url", errInvalidRoute, spec)
+	}
+	return &route{
+		host:   strings.ToLower(host),
+		prefix: prefix,
+		target: targetURL,
+	}, nil
+}
+
+// newRouter creates a router from the default target and additional route specifications.
+// The default target, if set, is used for all requests that do not match any other route.
+func newRouter(defaultTarget string, specs []string, transport http.RoundTripper) (*router, error) {
+	routes := make([]*route, 0, len(specs)+1)
+	for _, spec := range specs {
+		r, err := parseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	if defaultTarget != "" {
+		r, err := parseRoute("=" + defaultTarget)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	if len(routes) == 0 {
+		return nil, errNoRoute
+	}
+	// host specific routes win over generic ones, longer prefixes win over shorter ones.
+	sort.SliceStable(routes, func(i, j int) bool {
+		if (routes[i].host != "") != (routes[j].host != "") {
+			return routes[i].host != ""
+		}
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	for _, r := range routes {
+		r.proxy = httputil.NewSingleHostReverseProxy(r.target)
+		r.proxy.Transport = transport
+	}
+	return &router{routes: routes}, nil
+}
+
+// match returns the route for the given request or nil if no route matches.
+func (rt *router) match(req *http.Request) *route {
+	host := strings.ToLower(req.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, r := range rt.routes {
+		if r.host != "" && r.host != host {
+			continue
+		}
+		if r.matchPath(req.URL.Path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// matchPath reports whether the path is the prefix or below it, so "/api" matches "/api/info" but not "/apiary".
+func (r *route) matchPath(path string) bool {
+	if !strings.HasPrefix(path, r.prefix) {
+		return false
+	}
+	return len(path) == len(r.prefix) || strings.HasSuffix(r.prefix, "/") || path[len(r.prefix)] == '/'
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r := rt.match(req)
+	if r == nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if recorder, ok := w.(*statusRecorder); ok {
+		recorder.upstream = r.target.String()
+	}
+	r.proxy.ServeHTTP(w, req)
+}
+
+// newUpstreamTransport returns the transport used to reach upstream targets.
+// The timeout limits dialing, the TLS handshake and waiting for response headers,
+// but not the lifetime of upgraded (websocket) connections.
+func newUpstreamTransport(timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: upstreamKeepAlive}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
@@ -0,0 +1,73 @@
+package exit
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		spec       string
+		wantHost   string
+		wantPrefix string
+		wantTarget string
+		wantErr    bool
+	}{
+		{spec: "/api=http://api:8080", wantPrefix: "/api", wantTarget: "http://api:8080"},
+		{spec: "App.example.com=http://app:3000", wantHost: "app.example.com", wantPrefix: "/", wantTarget: "http://app:3000"},
+		{spec: "ws.example.com/socket=http://ws:9000", wantHost: "ws.example.com", wantPrefix: "/socket", wantTarget: "http://ws:9000"},
+		{spec: "/api", wantErr: true},
+		{spec: "/api=api:8080", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRoute(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRoute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.host != tt.wantHost || got.prefix != tt.wantPrefix || got.target.String() != tt.wantTarget {
+				t.Errorf("parseRoute() = %s %s %s", got.host, got.prefix, got.target)
+			}
+		})
+	}
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt, err := newRouter("http://default:80", []string{
+		"/api=http://api:8080",
+		"/api/v2=http://api-v2:8080",
+		"app.example.com=http://app:3000",
+	}, nil)
+	if err != nil {
+		t.Fatalf("newRouter() error = %v", err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://other.example.com/", want: "http://default:80"},
+		{url: "https://other.example.com/api/info", want: "http://api:8080"},
+		{url: "https://other.example.com/api/v2/info", want: "http://api-v2:8080"},
+		{url: "https://other.example.com/api", want: "http://api:8080"},
+		{url: "https://other.example.com/apiary", want: "http://default:80"},
+		{url: "https://other.example.com/api/v2x", want: "http://api:8080"},
+		{url: "https://app.example.com:4443/api/info", want: "http://app:3000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got := rt.match(httptest.NewRequest("GET", tt.url, nil))
+			if got == nil || got.target.String() != tt.want {
+				t.Errorf("match() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRouterWithoutRoutes(t *testing.T) {
+	if _, err := newRouter("", nil, nil); err == nil {
+		t.Fatal("expected error for router without routes")
+	}
+}
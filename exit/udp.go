@@ -0,0 +1,172 @@
+package exit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/protocol"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	// maxDatagramSize is the maximum size of an udp datagram.
+	maxDatagramSize = 65535
+	// defaultUDPIdleTimeout is used if no idle timeout is configured.
+	defaultUDPIdleTimeout = time.Minute
+)
+
+// udpAssociation maps the datagrams of an entry node association to a single udp socket, like a NAT.
+// It is released after it was idle for the configured timeout.
+type udpAssociation struct {
+	conn *net.UDPConn
+	peer *netstr.NostrPacketConn
+	// origins maps the remote addresses to the destinations requested by the entry node,
+	// so replies carry the address the client sent the datagram to.
+	origins *xsync.MapOf[string, string]
+	// remotes caches the resolved address by destination, so each destination is resolved once.
+	remotes *xsync.MapOf[string, *net.UDPAddr]
+	// lastActive is the unix nano time of the last datagram in either direction.
+	lastActive atomic.Int64
+}
+
+// Close closes the udp socket and tells the entry node to release the association.
+func (a *udpAssociation) Close() error {
+	return errors.Join(a.conn.Close(), a.peer.Close())
+}
+
+func (a *udpAssociation) touch() {
+	a.lastActive.Store(time.Now().UnixNano())
+}
+
+func (a *udpAssociation) idle(timeout time.Duration) bool {
+	return time.Since(time.Unix(0, a.lastActive.Load())) >= timeout
+}
+
+// handleDatagram sends the datagram of an entry node to its destination.
+// The association of the entry node session is created with the first datagram.
+// Requested is the destination as requested by the entry node, before it was mapped to the backend host.
+func (e *Exit) handleDatagram(
+	ctx context.Context,
+	msg nostr.IncomingEvent,
+	protocolMessage *protocol.Message,
+	requested string,
+) {
+	association, err := e.association(ctx, msg, protocolMessage)
+	if err != nil {
+		slog.Error("could not create udp association", "key", protocolMessage.Key, "error", err)
+		return
+	}
+	if association == nil {
+		return
+	}
+	remote, err := association.resolve(protocolMessage.Destination, requested)
+	if err != nil {
+		slog.Error("could not resolve datagram destination", "destination", protocolMessage.Destination, "error", err)
+		return
+	}
+	association.touch()
+	if _, err = association.conn.WriteToUDP(protocolMessage.Data, remote); err != nil {
+		slog.Error("could not send datagram", "destination", remote, "error", err)
+	}
+}
+
+// resolve returns the address of the destination. It is resolved with the first datagram to the destination.
+func (a *udpAssociation) resolve(destination, requested string) (*net.UDPAddr, error) {
+	if remote, ok := a.remotes.Load(destination); ok {
+		return remote, nil
+	}
+	remote, err := net.ResolveUDPAddr("udp", destination)
+	if err != nil {
+		return nil, err
+	}
+	a.origins.Store(remote.String(), requested)
+	a.remotes.Store(destination, remote)
+	return remote, nil
+}
+
+// association returns the association of the session and creates it if needed.
+// It returns nil if the exit node is shutting down.
+func (e *Exit) association(
+	ctx context.Context,
+	msg nostr.IncomingEvent,
+	protocolMessage *protocol.Message,
+) (*udpAssociation, error) {
+	key := protocolMessage.Key.String()
+	e.mutexMap.Lock(key)
+	defer e.mutexMap.Unlock(key)
+	if association, ok := e.associations.Load(key); ok {
+		return association, nil
+	}
+	if e.closing.Load() {
+		slog.Info("rejecting new udp association during shutdown", "key", key)
+		return nil, nil
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	session, err := netstr.NewSession(ctx, e.config.NostrPrivateKey, msg, protocolMessage.Key)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	association := &udpAssociation{
+		conn:    conn,
+		peer:    netstr.NewPacketConn(session),
+		origins: xsync.NewMapOf[string, string](),
+		remotes: xsync.NewMapOf[string, *net.UDPAddr](),
+	}
+	association.touch()
+	e.associations.Store(key, association)
+	done := e.trackSession(key, association)
+	slog.Info("created udp association", "key", key, "address", conn.LocalAddr())
+	go func() {
+		defer done()
+		defer e.associations.Delete(key)
+		defer association.Close()
+		e.relayDatagrams(association)
+		slog.Info("released udp association", "key", key)
+	}()
+	return association, nil
+}
+
+// relayDatagrams sends the datagrams received on the association socket to the entry node,
+// until the association was idle for the configured timeout or is closed.
+func (e *Exit) relayDatagrams(association *udpAssociation) {
+	timeout := e.config.UDPIdleTimeout
+	if timeout <= 0 {
+		timeout = defaultUDPIdleTimeout
+	}
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		if err := association.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return
+		}
+		n, remote, err := association.conn.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && !association.idle(timeout) {
+				// datagrams were sent in the meantime
+				continue
+			}
+			return
+		}
+		origin, ok := association.origins.Load(remote.String())
+		if !ok {
+			// only peers the entry node sent datagrams to may answer
+			slog.Debug("dropping datagram from unknown peer", "peer", remote)
+			continue
+		}
+		association.touch()
+		if _, err = association.peer.WriteTo(buffer[:n], netstr.DatagramAddr(origin)); err != nil {
+			slog.Error("could not relay datagram", "peer", remote, "error", err)
+		}
+	}
+}
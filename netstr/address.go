@@ -1,13 +1,29 @@
 package netstr
 
-// NostrAddress represents a type that holds the profile and public key of a Nostr address.
+import (
+	"github.com/asmogo/nws/codec"
+	"github.com/google/uuid"
+)
+
+// NostrAddress is the address of one end of a Nostr session, the net.Addr of a NostrConnection.
+// It holds the public key, the relays the public key is reached through and the ID of the session, if any.
 type NostrAddress struct {
-	Nprofile string
-	pubkey   string
+	PublicKey string
+	Relays    []string
+	SessionID uuid.UUID
 }
 
+// String returns the nprofile of the public key and relays, or the npub if the relays can't be encoded.
 func (n NostrAddress) String() string {
-	return n.Nprofile
+	address := codec.Address{PublicKey: n.PublicKey, Relays: n.Relays}
+	if nprofile, err := address.Encode(codec.FormatNprofile); err == nil {
+		return nprofile
+	}
+	npub, err := address.Encode(codec.FormatNpub)
+	if err != nil {
+		return n.PublicKey
+	}
+	return npub
 }
 
 // Network returns the network type of the NostrAddress, which is "nostr".
@@ -0,0 +1,278 @@
+package netstr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// AddressBookSuffix is the pseudo top level domain of address book names, e.g. "mint.nws".
+	AddressBookSuffix = ".nws"
+	// AddressBookListID is the "d" tag of NIP-51 follow sets (kind 30000) used as address book.
+	AddressBookListID = "nws"
+)
+
+var (
+	errInvalidName    = errors.New("invalid address book name")
+	errUnknownName    = errors.New("unknown address book name")
+	errInvalidAddress = errors.New("invalid address book destination")
+)
+
+// AddressEntry is the destination of an address book name.
+type AddressEntry struct {
+	Name      string
+	PublicKey string
+	Relays    []string
+}
+
+// Address returns the codec address of the entry.
+func (e AddressEntry) Address() codec.Address {
+	return codec.Address{PublicKey: e.PublicKey, Relays: e.Relays}
+}
+
+// Destination returns the nprofile of the entry, or the npub if it has no relays.
+func (e AddressEntry) Destination() string {
+	format := codec.FormatNprofile
+	if len(e.Relays) == 0 {
+		format = codec.FormatNpub
+	}
+	destination, _ := e.Address().Encode(format)
+	return destination
+}
+
+// Domain returns the ".nostr" domain of the entry, which is what exit nodes expect as destination.
+func (e AddressEntry) Domain() string {
+	domain, _ := e.Address().Encode(codec.FormatDomain)
+	return domain
+}
+
+// AddressBook maps human-readable names like "mint.nws" to Nostr destinations.
+// Entries are kept in a JSON file that maps names to npub, nprofile or .nostr destinations.
+// Names of a NIP-51 list are added with Subscribe; entries of the file take precedence.
+type AddressBook struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]AddressEntry
+	list    map[string]AddressEntry
+}
+
+// LoadAddressBook loads the address book file at path. A missing file is an empty address book.
+func LoadAddressBook(path string) (*AddressBook, error) {
+	book := &AddressBook{
+		path:    path,
+		entries: make(map[string]AddressEntry),
+		list:    make(map[string]AddressEntry),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address book: %w", err)
+	}
+	var destinations map[string]string
+	if err = json.Unmarshal(data, &destinations); err != nil {
+		return nil, fmt.Errorf("failed to parse address book %s: %w", path, err)
+	}
+	for name, destination := range destinations {
+		entry, err := newAddressEntry(name, destination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse address book %s: %w", path, err)
+		}
+		book.entries[entry.Name] = entry
+	}
+	return book, nil
+}
+
+func newAddressEntry(name, destination string) (AddressEntry, error) {
+	name, err := normalizeName(name)
+	if err != nil {
+		return AddressEntry{}, err
+	}
+	publicKey, relays, err := ParseDestination(destination)
+	if err != nil {
+		return AddressEntry{}, fmt.Errorf("%w %q: %w", errInvalidAddress, destination, err)
+	}
+	return AddressEntry{Name: name, PublicKey: publicKey, Relays: relays}, nil
+}
+
+// normalizeName lowercases the name and checks that it ends with AddressBookSuffix.
+func normalizeName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	label := strings.TrimSuffix(name, AddressBookSuffix)
+	if label == name || label == "" || strings.HasPrefix(label, ".") || strings.ContainsAny(label, " :/") {
+		return "", fmt.Errorf("%w %q: names end with %s", errInvalidName, name, AddressBookSuffix)
+	}
+	return name, nil
+}
+
+// IsAddressBookName reports whether the host is in the address book namespace.
+func IsAddressBookName(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), AddressBookSuffix)
+}
+
+// Lookup returns the entry of the name.
+func (b *AddressBook) Lookup(name string) (AddressEntry, bool) {
+	name = strings.ToLower(name)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if entry, ok := b.entries[name]; ok {
+		return entry, true
+	}
+	entry, ok := b.list[name]
+	return entry, ok
+}
+
+// Entries returns the entries of the file and the list, sorted by name.
+func (b *AddressBook) Entries() []AddressEntry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries := make([]AddressEntry, 0, len(b.entries)+len(b.list))
+	for _, entry := range b.entries {
+		entries = append(entries, entry)
+	}
+	for name, entry := range b.list {
+		if _, ok := b.entries[name]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// Add adds or replaces the name in the address book file.
+func (b *AddressBook) Add(name, destination string) (AddressEntry, error) {
+	entry, err := newAddressEntry(name, destination)
+	if err != nil {
+		return AddressEntry{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	previous, existed := b.entries[entry.Name]
+	b.entries[entry.Name] = entry
+	if err = b.save(); err != nil {
+		if existed {
+			b.entries[entry.Name] = previous
+		} else {
+			delete(b.entries, entry.Name)
+		}
+		return AddressEntry{}, err
+	}
+	return entry, nil
+}
+
+// Remove removes the name from the address book file.
+func (b *AddressBook) Remove(name string) error {
+	name = strings.ToLower(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.entries[name]
+	if !ok {
+		return fmt.Errorf("%w %q", errUnknownName, name)
+	}
+	delete(b.entries, name)
+	if err := b.save(); err != nil {
+		b.entries[name] = entry
+		return err
+	}
+	return nil
+}
+
+// save writes the file entries atomically. It must be called with the lock held.
+func (b *AddressBook) save() error {
+	destinations := make(map[string]string, len(b.entries))
+	for name, entry := range b.entries {
+		destinations[name] = entry.Destination()
+	}
+	data, err := json.MarshalIndent(destinations, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode address book: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write address book: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write address book: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write address book: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), b.path); err != nil {
+		return fmt.Errorf("failed to write address book: %w", err)
+	}
+	return nil
+}
+
+// Subscribe keeps the address book in sync with the NIP-51 follow set (kind 30000, "d" tag AddressBookListID)
+// of the author, an npub, nprofile or hex public key. The petname of each "p" tag is used as name,
+// with AddressBookSuffix appended if missing. It returns once the subscription was created.
+func (b *AddressBook) Subscribe(ctx context.Context, pool *nostr.SimplePool, relays []string, author string) error {
+	publicKey, authorRelays, err := ParseDestination(author)
+	if err != nil {
+		return fmt.Errorf("invalid address book list author %q: %w", author, err)
+	}
+	if len(authorRelays) > 0 {
+		relays = authorRelays
+	}
+	events := pool.SubMany(ctx, relays, nostr.Filters{{
+		Kinds:   []int{nostr.KindCategorizedPeopleList},
+		Authors: []string{publicKey},
+		Tags:    nostr.TagMap{"d": []string{AddressBookListID}},
+	}})
+	go func() {
+		var latest nostr.Timestamp
+		for event := range events {
+			// replaceable events, only the latest version counts
+			if event.CreatedAt < latest {
+				continue
+			}
+			latest = event.CreatedAt
+			list := parseAddressList(event.Event)
+			b.mu.Lock()
+			b.list = list
+			b.mu.Unlock()
+			slog.Info("updated address book list", "author", publicKey, "entries", len(list))
+		}
+	}()
+	return nil
+}
+
+// parseAddressList returns the entries of the "p" tags with a petname: ["p", <pubkey>, <relay>, <petname>].
+func parseAddressList(event *nostr.Event) map[string]AddressEntry {
+	list := make(map[string]AddressEntry)
+	for _, tag := range event.Tags.GetAll([]string{"p"}) {
+		if len(tag) < 4 || !nostr.IsValidPublicKey(tag[1]) {
+			continue
+		}
+		name := strings.ToLower(tag[3])
+		if !strings.HasSuffix(name, AddressBookSuffix) {
+			name += AddressBookSuffix
+		}
+		name, err := normalizeName(name)
+		if err != nil {
+			slog.Debug("skipping address book list entry", "error", err)
+			continue
+		}
+		entry := AddressEntry{Name: name, PublicKey: tag[1]}
+		if tag[2] != "" {
+			entry.Relays = []string{tag[2]}
+		}
+		list[name] = entry
+	}
+	return list
+}
@@ -0,0 +1,90 @@
+package netstr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPublicKey = "452ebe58d395b1b196a9b8c82b038b6895cb02b683d0c253a955068dba1facd0"
+
+func TestAddressBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addressbook.json")
+	book, err := LoadAddressBook(path)
+	require.NoError(t, err)
+	assert.Empty(t, book.Entries())
+
+	nprofile, err := nip19.EncodeProfile(testPublicKey, []string{"wss://relay.example"})
+	require.NoError(t, err)
+	entry, err := book.Add("Mint.nws", nprofile)
+	require.NoError(t, err)
+	assert.Equal(t, "mint.nws", entry.Name)
+	assert.Equal(t, nprofile, entry.Destination())
+
+	_, err = book.Add("mint", nprofile)
+	assert.ErrorIs(t, err, errInvalidName)
+	_, err = book.Add("wallet.nws", "example.com")
+	assert.ErrorIs(t, err, errInvalidAddress)
+
+	// the domain of an entry decodes to the same destination
+	publicKey, relays, err := ParseDestination(entry.Domain())
+	require.NoError(t, err)
+	assert.Equal(t, testPublicKey, publicKey)
+	assert.Equal(t, []string{"wss://relay.example"}, relays)
+
+	reloaded, err := LoadAddressBook(path)
+	require.NoError(t, err)
+	loaded, ok := reloaded.Lookup("MINT.nws")
+	require.True(t, ok)
+	assert.Equal(t, entry, loaded)
+
+	require.NoError(t, reloaded.Remove("mint.nws"))
+	assert.ErrorIs(t, reloaded.Remove("mint.nws"), errUnknownName)
+	reloaded, err = LoadAddressBook(path)
+	require.NoError(t, err)
+	assert.Empty(t, reloaded.Entries())
+}
+
+func TestLoadAddressBookInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addressbook.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"mint": "npub1invalid"}`), 0o600))
+	_, err := LoadAddressBook(path)
+	assert.Error(t, err)
+}
+
+func TestParseAddressList(t *testing.T) {
+	list := parseAddressList(&nostr.Event{Tags: nostr.Tags{
+		{"d", AddressBookListID},
+		{"p", testPublicKey, "wss://relay.example", "mint"},
+		{"p", testPublicKey, "", "wallet.nws"},
+		{"p", testPublicKey},
+		{"p", "invalid", "", "broken"},
+	}})
+	assert.Len(t, list, 2)
+	assert.Equal(t, []string{"wss://relay.example"}, list["mint.nws"].Relays)
+	assert.Nil(t, list["wallet.nws"].Relays)
+}
+
+func TestNostrDNSResolveAddressBook(t *testing.T) {
+	book, err := LoadAddressBook(filepath.Join(t.TempDir(), "addressbook.json"))
+	require.NoError(t, err)
+	npub, err := nip19.EncodePublicKey(testPublicKey)
+	require.NoError(t, err)
+	_, err = book.Add("mint.nws", npub)
+	require.NoError(t, err)
+
+	dns := NewNostrDNS(nil, nil, WithAddressBook(book))
+	ctx, ip, err := dns.Resolve(context.Background(), "mint.nws")
+	require.NoError(t, err)
+	assert.Nil(t, ip)
+	assert.Equal(t, testPublicKey, ctx.Value(TargetPublicKey))
+
+	_, _, err = dns.Resolve(context.Background(), "unknown.nws")
+	assert.ErrorIs(t, err, errUnknownName)
+}
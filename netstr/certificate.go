@@ -0,0 +1,97 @@
+package netstr
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/asmogo/nws/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	errNoCertificateEvent      = errors.New("failed to find certificate event")
+	errInvalidCertificateEvent = errors.New("invalid certificate event")
+	errCertificateNotPinned    = errors.New("peer certificate does not match the published certificate")
+)
+
+// FetchCertificate queries the relays for the certificate event published by the given public key.
+// The event signature is verified, so the returned certificate is the one the exit node signed with its Nostr key.
+func FetchCertificate(
+	ctx context.Context,
+	pool *nostr.SimplePool,
+	relays []string,
+	publicKey string,
+) (*x509.Certificate, error) {
+	event := pool.QuerySingle(ctx, relays, nostr.Filter{
+		Authors: []string{publicKey},
+		Kinds:   []int{protocol.KindCertificateEvent},
+		Tags:    nostr.TagMap{"p": []string{publicKey}},
+	})
+	if event == nil {
+		return nil, errNoCertificateEvent
+	}
+	return parseCertificateEvent(event.Event, publicKey)
+}
+
+// FetchDestinationCertificate fetches the published certificate for a .nostr domain, npub or nprofile destination.
+// Relays contained in the destination are preferred over the given default relays.
+func FetchDestinationCertificate(
+	ctx context.Context,
+	pool *nostr.SimplePool,
+	defaultRelays []string,
+	destination string,
+) (*x509.Certificate, error) {
+	publicKey, relays, err := ParseDestination(destination)
+	if err != nil {
+		return nil, err
+	}
+	if len(relays) == 0 {
+		relays = defaultRelays
+	}
+	return FetchCertificate(ctx, pool, relays, publicKey)
+}
+
+// parseCertificateEvent verifies the certificate event and returns the contained certificate.
+func parseCertificateEvent(event *nostr.Event, publicKey string) (*x509.Certificate, error) {
+	if event.PubKey != publicKey || event.Kind != protocol.KindCertificateEvent {
+		return nil, fmt.Errorf("%w: unexpected author or kind", errInvalidCertificateEvent)
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		return nil, fmt.Errorf("%w: invalid signature: %w", errInvalidCertificateEvent, err)
+	}
+	block, _ := pem.Decode([]byte(event.Content))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%w: no certificate found", errInvalidCertificateEvent)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidCertificateEvent, err)
+	}
+	return cert, nil
+}
+
+// VerifyPinnedCertificate returns a function that can be used as tls.Config.VerifyPeerCertificate.
+// It accepts the connection only if the leaf certificate presented by the peer equals the pinned certificate.
+func VerifyPinnedCertificate(pinned *x509.Certificate) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], pinned.Raw) {
+			return errCertificateNotPinned
+		}
+		return nil
+	}
+}
+
+// PinnedTLSConfig returns a tls.Config that trusts exactly the pinned certificate.
+// Chain and hostname verification is replaced by the pin, because exit certificates are self-signed.
+func PinnedTLSConfig(pinned *x509.Certificate) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify:    true, //nolint:gosec // verification is done by VerifyPeerCertificate
+		VerifyPeerCertificate: VerifyPinnedCertificate(pinned),
+		MinVersion:            tls.VersionTLS12,
+	}
+}
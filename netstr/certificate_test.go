@@ -0,0 +1,88 @@
+package netstr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func createTestCertificate(t *testing.T) (*x509.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.nostr"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseCertificateEvent(t *testing.T) {
+	cert, certPEM := createTestCertificate(t)
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, _ := nostr.GetPublicKey(privateKey)
+	event := nostr.Event{
+		CreatedAt: nostr.Now(),
+		PubKey:    publicKey,
+		Kind:      protocol.KindCertificateEvent,
+		Content:   string(certPEM),
+		Tags:      nostr.Tags{nostr.Tag{"p", publicKey}},
+	}
+	if err := event.Sign(privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseCertificateEvent(&event, publicKey)
+	if err != nil {
+		t.Fatalf("parseCertificateEvent() error = %v", err)
+	}
+	if !got.Equal(cert) {
+		t.Error("parseCertificateEvent() returned a different certificate")
+	}
+
+	otherKey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if _, err = parseCertificateEvent(&event, otherKey); err == nil {
+		t.Error("expected error for certificate event of another author")
+	}
+
+	event.Content = "tampered"
+	if _, err = parseCertificateEvent(&event, publicKey); err == nil {
+		t.Error("expected error for tampered certificate event")
+	}
+}
+
+func TestVerifyPinnedCertificate(t *testing.T) {
+	pinned, _ := createTestCertificate(t)
+	other, _ := createTestCertificate(t)
+	verify := VerifyPinnedCertificate(pinned)
+	if err := verify([][]byte{pinned.Raw}, nil); err != nil {
+		t.Errorf("expected pinned certificate to be accepted: %v", err)
+	}
+	if err := verify([][]byte{other.Raw}, nil); err == nil {
+		t.Error("expected other certificate to be rejected")
+	}
+	if err := verify(nil, nil); err == nil {
+		t.Error("expected missing certificate to be rejected")
+	}
+}
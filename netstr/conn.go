@@ -3,22 +3,20 @@ package netstr
 import (
 	"bytes"
 	"context"
-	"encoding/base32"
 	"encoding/base64"
-	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/asmogo/nws/codec"
 	"github.com/asmogo/nws/protocol"
-	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/ekzyis/nip44"
 	"github.com/google/uuid"
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/samber/lo"
 )
 
@@ -67,6 +65,11 @@ type NostrConnection struct {
 	sub             bool
 	defaultRelays   []string
 	targetPublicKey string
+
+	// peerClosed is set once the peer sent a close message. Further reads return io.EOF.
+	peerClosed bool
+	// closeOnce makes sure the close message is only sent once.
+	closeOnce sync.Once
 }
 
 var errContextCanceled = errors.New("context canceled")
@@ -76,7 +79,10 @@ var errContextCanceled = errors.New("context canceled")
 // Parameters:
 // - event: The incoming event to be written to the subscription channel.
 func (nc *NostrConnection) WriteNostrEvent(event nostr.IncomingEvent) {
-	nc.subscriptionChan <- event
+	select {
+	case nc.subscriptionChan <- event:
+	case <-nc.ctx.Done():
+	}
 }
 
 // NewConnection creates a new NostrConnection object with the provided context and options.
@@ -117,57 +123,93 @@ func (nc *NostrConnection) Read(b []byte) (int, error) {
 // It returns the number of bytes copied and any error encountered.
 // If the context is canceled, it returns an error with "context canceled" message.
 func (nc *NostrConnection) handleNostrRead(buffer []byte) (int, error) {
+	message, err := nc.readMessage()
+	if err != nil || message == nil {
+		return 0, err
+	}
+	n := copy(buffer, message.Data)
+	return n, nil
+}
+
+// readMessage reads the next message of the peer from the subscription channel.
+// It skips events that were already read and returns io.EOF once the peer closed the session.
+// A nil message without error is returned if the subscription was closed.
+func (nc *NostrConnection) readMessage() (*protocol.Message, error) {
+	if nc.peerClosed {
+		return nil, io.EOF
+	}
 	for {
 		select {
 		case event := <-nc.subscriptionChan:
 			if event.Relay == nil {
-				return 0, nil
+				return nil, nil
 			}
 			// check if we have already read this event
 			if lo.Contains(nc.readIDs, event.ID) {
 				continue
 			}
 			nc.readIDs = append(nc.readIDs, event.ID)
-			// hex decode the target public key
-			privateKeyBytes, targetPublicKeyBytes, err := protocol.GetEncryptionKeys(nc.privateKey, event.PubKey)
-			if err != nil {
-				return 0, fmt.Errorf("could not get encryption keys: %w", err)
-			}
-			sharedKey, err := nip44.GenerateConversationKey(privateKeyBytes, targetPublicKeyBytes)
+			message, err := DecryptMessage(nc.privateKey, event)
 			if err != nil {
-				return 0, fmt.Errorf("could not compute shared key: %w", err)
+				return nil, err
 			}
-			decodedMessage, err := nip44.Decrypt(sharedKey, event.Content)
-			if err != nil {
-				return 0, fmt.Errorf("could not decrypt message: %w", err)
-			}
-			message, err := protocol.UnmarshalJSON([]byte(decodedMessage))
-			if err != nil {
-				return 0, fmt.Errorf("could not unmarshal message: %w", err)
+			if message.Type == protocol.MessageClose {
+				nc.peerClosed = true
+				return nil, io.EOF
 			}
 			slog.Debug("reading",
 				slog.String("event", event.ID),
 				slog.String("content", base64.StdEncoding.EncodeToString(message.Data)),
 			)
-			n := copy(buffer, message.Data)
-			return n, nil
+			return message, nil
 		case <-nc.ctx.Done():
-			return 0, errContextCanceled
+			return nil, errContextCanceled
 		default:
 			time.Sleep(time.Millisecond * 100)
 		}
 	}
 }
 
+// DecryptMessage decrypts the content of an event sent to the private key and unmarshals the protocol message.
+func DecryptMessage(privateKey string, event nostr.IncomingEvent) (*protocol.Message, error) {
+	// hex decode the target public key
+	privateKeyBytes, targetPublicKeyBytes, err := protocol.GetEncryptionKeys(privateKey, event.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not get encryption keys: %w", err)
+	}
+	sharedKey, err := nip44.GenerateConversationKey(privateKeyBytes, targetPublicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not compute shared key: %w", err)
+	}
+	decodedMessage, err := nip44.Decrypt(sharedKey, event.Content)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt message: %w", err)
+	}
+	message, err := protocol.UnmarshalJSON([]byte(decodedMessage))
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal message: %w", err)
+	}
+	return message, nil
+}
+
 // Write writes data to the connection.
 // It delegates the writing logic to handleNostrWrite method.
 // The number of bytes written and error (if any) are returned.
 func (nc *NostrConnection) Write(b []byte) (int, error) {
-	return nc.handleNostrWrite(b)
+	return nc.handleNostrWrite(b, protocol.MessageTypeSocks5)
+}
+
+// handleNostrWrite creates a signed event of the given message type containing the buffer
+// and publishes it to the relays of the destination.
+func (nc *NostrConnection) handleNostrWrite(buffer []byte, messageType protocol.MessageType) (int, error) {
+	return nc.writeMessage(buffer, messageType, nc.dst)
 }
 
-// Go lang
-func (nc *NostrConnection) handleNostrWrite(buffer []byte) (int, error) {
+// writeMessage creates a signed event of the given message type and destination containing the buffer
+// and publishes it to the relays of the connection destination.
+func (nc *NostrConnection) writeMessage(
+	buffer []byte, messageType protocol.MessageType, destination string,
+) (int, error) {
 	if nc.ctx.Err() != nil {
 		return 0, fmt.Errorf("context canceled: %w", nc.ctx.Err())
 	}
@@ -179,7 +221,7 @@ func (nc *NostrConnection) handleNostrWrite(buffer []byte) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("could not create event signer: %w", err)
 	}
-	signedEvent, err := nc.createSignedEvent(signer, buffer, publicKey, relays)
+	signedEvent, err := nc.createSignedEvent(signer, buffer, messageType, destination, publicKey, relays)
 	if err != nil {
 		return 0, fmt.Errorf("could not create signed event: %w", err)
 	}
@@ -198,13 +240,15 @@ func (nc *NostrConnection) handleNostrWrite(buffer []byte) (int, error) {
 func (nc *NostrConnection) createSignedEvent(
 	signer *protocol.EventSigner,
 	b []byte,
+	messageType protocol.MessageType,
+	destination string,
 	publicKey string,
 	relays []string,
 ) (nostr.Event, error) {
 	opts := []protocol.MessageOption{
 		protocol.WithUUID(nc.uuid),
-		protocol.WithType(protocol.MessageTypeSocks5),
-		protocol.WithDestination(nc.dst),
+		protocol.WithType(messageType),
+		protocol.WithDestination(destination),
 		protocol.WithData(b),
 	}
 	signedEvent, err := signer.CreateSignedEvent(
@@ -222,25 +266,30 @@ func (nc *NostrConnection) createSignedEvent(
 	}
 	nc.writeIDs = append(nc.writeIDs, signedEvent.ID)
 	if nc.sub {
-		nc.sub = false
-		now := nostr.Now()
-		incomingEventChannel := nc.pool.SubMany(nc.ctx, relays,
-			nostr.Filters{
-				{
-					Kinds:   []int{protocol.KindEphemeralEvent},
-					Authors: []string{publicKey},
-					Since:   &now,
-					Tags: nostr.TagMap{
-						"p": []string{signedEvent.PubKey},
-					},
-				},
-			},
-		)
-		nc.subscriptionChan = incomingEventChannel
+		nc.subscribe(publicKey, signedEvent.PubKey, relays)
 	}
 	return signedEvent, nil
 }
 
+// subscribe subscribes to the events the peer sends to this connection.
+func (nc *NostrConnection) subscribe(peerPublicKey, publicKey string, relays []string) {
+	nc.sub = false
+	now := nostr.Now()
+	incomingEventChannel := nc.pool.SubMany(nc.ctx, relays,
+		nostr.Filters{
+			{
+				Kinds:   []int{protocol.KindEphemeralEvent},
+				Authors: []string{peerPublicKey},
+				Since:   &now,
+				Tags: nostr.TagMap{
+					"p": []string{publicKey},
+				},
+			},
+		},
+	)
+	nc.subscriptionChan = incomingEventChannel
+}
+
 func (nc *NostrConnection) publishEventToRelays(ev nostr.Event, relays []string) error {
 	for _, responseRelay := range relays {
 		var relay *nostr.Relay
@@ -260,94 +309,66 @@ func (nc *NostrConnection) appendSentBytes(b []byte) {
 	nc.sentBytes = append(nc.sentBytes, b)
 }
 
-// parseDestination takes a destination string and returns a public key and relays.
-// The destination can be "npub" or "nprofile".
-// If the prefix is "npub", the public key is extracted.
-// If the prefix is "nprofile", the public key and relays are extracted.
+// parseDestination returns the public key and relays of the connection destination.
+// Destinations outside the nostr namespace (clearnet domains and ip addresses) are
+// relayed through the configured target public key and default relays.
 // Returns the public key, relays (if any), and any error encountered.
 func (nc *NostrConnection) parseDestination() (string, []string, error) {
-	// check if destination ends with .nostr
-	if strings.HasPrefix(nc.dst, "npub") || strings.HasPrefix(nc.dst, "nprofile") {
-		// destination can be npub or nprofile
-		prefix, pubKey, err := nip19.Decode(nc.dst)
-
-		if err != nil {
-			return "", nil, fmt.Errorf("could not decode destination: %w", err)
-		}
-
-		var relays []string
-		var publicKey string
-
-		switch prefix {
-		case "npub":
-			publicKey = pubKey.(string)
-		case "nprofile":
-			profilePointer := pubKey.(nostr.ProfilePointer)
-			publicKey = profilePointer.PublicKey
-			relays = profilePointer.Relays
-		}
-		return publicKey, relays, nil
-	}
-	return nc.parseDestinationDomain()
-}
-
-func (nc *NostrConnection) parseDestinationDomain() (string, []string, error) {
-	url, err := protocol.Parse(nc.dst)
-	if err != nil {
-		return "", nil, err
-	}
-	if !url.IsDomain {
-		// try to parse as ip
-		ip := net.ParseIP(url.Name)
-		if ip != nil {
-			return nc.targetPublicKey, nc.defaultRelays, nil
-		}
-		return "", nil, fmt.Errorf("destination is not a domain")
-
-	}
-	if url.TLD != "nostr" {
-		// parse public key
-		/*pubKey,err := nostr.GetPublicKey(nc.privateKey)
-		if err != nil {
-			return "", nil, err
-		}*/
+	publicKey, relays, err := ParseDestination(nc.dst)
+	if errors.Is(err, ErrNoNostrDestination) {
 		return nc.targetPublicKey, nc.defaultRelays, nil
 	}
-	subdomains := make([]string, 0)
-	split := strings.Split(url.SubName, ".")
-	for _, subdomain := range split {
-		decodedSubDomain, err := base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(subdomain))
-		if err != nil {
-			continue
-		}
-		subdomains = append(subdomains, string(decodedSubDomain))
-	}
+	return publicKey, relays, err
+}
 
-	// base32 decode the subdomain
-	decodedPubKey, err := base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(url.Name))
+// ErrNoNostrDestination is returned by ParseDestination for clearnet domains and ip addresses.
+var ErrNoNostrDestination = codec.ErrNoNostrAddress
 
+// ParseDestination takes a destination string and returns a public key and relays.
+// The destination can be an npub, nprofile or ".nostr" domain, with an optional port, see codec.ParseAddress.
+// Returns the public key, relays (if any), and any error encountered.
+func ParseDestination(dst string) (string, []string, error) {
+	address, err := codec.ParseAddress(dst)
 	if err != nil {
 		return "", nil, err
 	}
-	pk, err := schnorr.ParsePubKey(decodedPubKey)
-	if err != nil {
-		return "", nil, err
-	}
-	// todo -- check if this is correct
-	return hex.EncodeToString(pk.SerializeCompressed())[2:], subdomains, nil
+	return address.PublicKey, address.Relays, nil
 }
 
+// Close closes the connection. Unless the peer closed the session already,
+// a close message is sent to the peer, so it can release the session as well.
 func (nc *NostrConnection) Close() error {
-	nc.cancel()
-	return nil
+	var err error
+	nc.closeOnce.Do(func() {
+		if !nc.peerClosed && nc.dst != "" && nc.ctx.Err() == nil {
+			if _, err = nc.handleNostrWrite(nil, protocol.MessageClose); err != nil {
+				err = fmt.Errorf("could not send close message: %w", err)
+			}
+		}
+		nc.cancel()
+	})
+	return err
 }
 
+// LocalAddr returns the NostrAddress of the connection's own public key,
+// which is reached through the same relays as the peer.
 func (nc *NostrConnection) LocalAddr() net.Addr {
-	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9333}
+	publicKey, err := nostr.GetPublicKey(nc.privateKey)
+	if err != nil {
+		return NostrAddress{SessionID: nc.uuid}
+	}
+	_, relays, _ := nc.parseDestination()
+	return NostrAddress{PublicKey: publicKey, Relays: relays, SessionID: nc.uuid}
 }
 
+// RemoteAddr returns the NostrAddress of the peer. For destinations outside the nostr namespace,
+// the peer is the exit node relaying the connection.
 func (nc *NostrConnection) RemoteAddr() net.Addr {
-	return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0}
+	publicKey, relays, err := nc.parseDestination()
+	if err != nil {
+		return NostrAddress{SessionID: nc.uuid}
+	}
+	return NostrAddress{PublicKey: publicKey, Relays: relays, SessionID: nc.uuid}
 }
 
 func (nc *NostrConnection) SetDeadline(_ time.Time) error {
@@ -6,6 +6,7 @@ import (
 	"github.com/asmogo/nws/protocol"
 	"github.com/ekzyis/nip44"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
 	"runtime"
 	"testing"
 
@@ -136,3 +137,32 @@ func TestNewConnection(t *testing.T) {
 		})
 	}
 }
+
+func TestNostrConnection_Addr(t *testing.T) {
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, err := nostr.GetPublicKey(privateKey)
+	assert.NoError(t, err)
+	peer, err := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	assert.NoError(t, err)
+	relays := []string{"wss://relay.example.com"}
+	key := uuid.New()
+	nprofile, err := nip19.EncodeProfile(peer, relays)
+	assert.NoError(t, err)
+
+	connection := NewConnection(context.Background(),
+		WithPrivateKey(privateKey), WithDst(nprofile+":443"), WithUUID(key))
+	defer connection.cancel()
+
+	remote, ok := connection.RemoteAddr().(NostrAddress)
+	assert.True(t, ok)
+	assert.Equal(t, "nostr", remote.Network())
+	assert.Equal(t, NostrAddress{PublicKey: peer, Relays: relays, SessionID: key}, remote)
+	assert.Equal(t, nprofile, remote.String())
+	assert.Equal(t, NostrAddress{PublicKey: publicKey, Relays: relays, SessionID: key}, connection.LocalAddr())
+
+	// clearnet destinations are relayed by the exit node
+	connection = NewConnection(context.Background(), WithPrivateKey(privateKey), WithDst("example.com:443"),
+		WithTargetPublicKey(peer), WithDefaultRelays(relays), WithUUID(key))
+	defer connection.cancel()
+	assert.Equal(t, NostrAddress{PublicKey: peer, Relays: relays, SessionID: key}, connection.RemoteAddr())
+}
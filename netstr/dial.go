@@ -18,60 +18,89 @@ type DialOptions struct {
 	ConnectionID    uuid.UUID
 	MessageType     protocol.MessageType
 	TargetPublicKey string
+	// TargetRelays are the relays of the target public key. DialSocks defaults them to the configured relays.
+	TargetRelays []string
+}
+
+// targetRelays returns the relays used to reach the target public key.
+func (o DialOptions) targetRelays(config *config.EntryConfig) []string {
+	if len(o.TargetRelays) > 0 {
+		return o.TargetRelays
+	}
+	return config.NostrRelays
 }
 
 // DialSocks connects to a destination using the provided SimplePool and returns a Dialer function.
-// It creates a new Connection using the specified context, private key, destination address,
-// It parses the destination address to get the public key and relays.
-// It creates a signed event using the private key, public key, and destination address.
-// It ensures that the relays are available in the pool and publishes the signed event to each relay.
-// Finally, it returns the Connection and nil error. If there are any errors, nil connection and the error are returned.
+// Destinations without relays are reached through the target relays or the configured relays, see Dial.
 func DialSocks(
 	options DialOptions,
 	config *config.EntryConfig,
 ) func(ctx context.Context, _, addr string) (net.Conn, error) {
 	return func(ctx context.Context, _, addr string) (net.Conn, error) {
-		key := nostr.GeneratePrivateKey()
-		connection := NewConnection(ctx,
-			WithPrivateKey(key),
-			WithDst(addr),
-			WithSub(),
-			WithDefaultRelays(config.NostrRelays),
-			WithTargetPublicKey(options.TargetPublicKey),
-			WithUUID(options.ConnectionID))
+		options.TargetRelays = options.targetRelays(config)
+		return Dial(ctx, options, addr)
+	}
+}
 
-		var publicKey string
-		var relays []string
-		var err error
-		if options.TargetPublicKey != "" {
-			publicKey, relays = options.TargetPublicKey, config.NostrRelays
-		} else {
-			publicKey, relays, err = connection.parseDestination()
-			if err != nil {
-				slog.Error("error parsing host", "error", err)
-				return nil, fmt.Errorf("error parsing host: %w", err)
-			}
-		}
-		// create nostr signed event
-		signer, err := protocol.NewEventSigner(key)
+// Dial creates a new Connection to the destination address.
+// If no target public key is set, the destination must be a .nostr domain, npub or nprofile,
+// whose public key and relays are used. Destinations without relays are reached through the TargetRelays.
+// It creates a signed event using a new private key, the public key, and destination address,
+// ensures that the relays are available in the pool and publishes the signed event to each relay.
+// Finally, it returns the Connection and nil error. If there are any errors, nil connection and the error are returned.
+// The context limits establishing the connection, not its lifetime.
+func Dial(ctx context.Context, options DialOptions, addr string) (net.Conn, error) {
+	key := nostr.GeneratePrivateKey()
+	// the context only limits publishing the request, the connection lives until it is closed
+	connection := NewConnection(context.WithoutCancel(ctx),
+		WithPrivateKey(key),
+		WithDst(addr),
+		WithSub(),
+		WithDefaultRelays(options.TargetRelays),
+		WithTargetPublicKey(options.TargetPublicKey),
+		WithUUID(options.ConnectionID))
+
+	var publicKey string
+	var relays []string
+	var err error
+	if options.TargetPublicKey != "" {
+		publicKey, relays = options.TargetPublicKey, options.TargetRelays
+	} else {
+		publicKey, relays, err = connection.parseDestination()
 		if err != nil {
-			return nil, fmt.Errorf("error creating signer: %w", err)
-		}
-		opts := []protocol.MessageOption{
-			protocol.WithType(options.MessageType),
-			protocol.WithUUID(options.ConnectionID),
+			slog.Error("error parsing host", "error", err)
+			connection.cancel()
+			return nil, fmt.Errorf("error parsing host: %w", err)
 		}
-		if options.PublicAddress != "" {
-			opts = append(opts, protocol.WithEntryPublicAddress(options.PublicAddress))
+		if len(relays) == 0 {
+			relays = options.TargetRelays
 		}
-		opts = append(opts, protocol.WithDestination(addr))
+	}
+	// create nostr signed event
+	signer, err := protocol.NewEventSigner(key)
+	if err != nil {
+		connection.cancel()
+		return nil, fmt.Errorf("error creating signer: %w", err)
+	}
+	opts := []protocol.MessageOption{
+		protocol.WithType(options.MessageType),
+		protocol.WithUUID(options.ConnectionID),
+	}
+	if options.PublicAddress != "" {
+		opts = append(opts, protocol.WithEntryPublicAddress(options.PublicAddress))
+	}
+	opts = append(opts, protocol.WithDestination(addr))
 
-		err = createAndPublish(ctx, signer, publicKey, opts, relays, options)
-		if err != nil {
-			return nil, fmt.Errorf("error publishing event: %w", err)
-		}
-		return connection, nil
+	// the exit node answers bind requests before the entry node writes any data
+	if options.MessageType == protocol.MessageBind {
+		connection.subscribe(publicKey, signer.PublicKey, relays)
+	}
+	err = createAndPublish(ctx, signer, publicKey, opts, relays, options)
+	if err != nil {
+		connection.cancel()
+		return nil, fmt.Errorf("error publishing event: %w", err)
 	}
+	return connection, nil
 }
 
 // createAndPublish creates a signed event using the provided signer, public key, message options, and relays.
@@ -5,17 +5,32 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"strings"
 	"time"
 
+	"github.com/asmogo/nws/codec"
 	"github.com/asmogo/nws/protocol"
 	"github.com/nbd-wtf/go-nostr"
 )
 
-// NostrDNS does not resolve anything.
+// NostrDNS resolves the exit node of a destination.
+// Address book names and NIP-05 identifiers are resolved to their exit node,
+// clearnet hosts to their ip address and a public exit node announced on the relays.
+// .nostr domains, npubs and nprofiles carry their exit node and are not resolved.
 type NostrDNS struct {
 	pool        *nostr.SimplePool
 	nostrRelays []string
+	addressBook *AddressBook
+	nip05       *NIP05Resolver
+}
+
+// NostrDNSOption configures a NostrDNS.
+type NostrDNSOption func(*NostrDNS)
+
+// WithAddressBook resolves names of the address book to their exit node.
+func WithAddressBook(book *AddressBook) NostrDNSOption {
+	return func(d *NostrDNS) {
+		d.addressBook = book
+	}
 }
 
 var (
@@ -24,18 +39,31 @@ var (
 	errExitNodeEventIsExpired    = errors.New("exit node event is expired")
 )
 
-func NewNostrDNS(pool *nostr.SimplePool, nostrRelays []string) *NostrDNS {
-	return &NostrDNS{
+// WithNIP05 resolves NIP-05 identifiers and .nws names of a domain to their exit node.
+func WithNIP05(resolver *NIP05Resolver) NostrDNSOption {
+	return func(d *NostrDNS) {
+		d.nip05 = resolver
+	}
+}
+
+func NewNostrDNS(pool *nostr.SimplePool, nostrRelays []string, opts ...NostrDNSOption) *NostrDNS {
+	d := &NostrDNS{
 		pool:        pool,
 		nostrRelays: nostrRelays,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 func (d NostrDNS) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
-	if strings.HasSuffix(name, ".nostr") || strings.HasPrefix(name, "npub") || strings.HasPrefix(name, "nprofile") {
-
+	if codec.IsAddress(name) {
 		return ctx, nil, nil
 	}
+	if IsNostrName(name) {
+		return d.resolveName(ctx, name)
+	}
 	addr, err := net.ResolveIPAddr("ip", name)
 	if err != nil {
 		return ctx, nil, fmt.Errorf("failed to resolve ip address: %w", err)
@@ -58,6 +86,40 @@ func (d NostrDNS) Resolve(ctx context.Context, name string) (context.Context, ne
 	return ctx, addr.IP, nil
 }
 
+// resolveName sets the exit node of an address book name or NIP-05 identifier as target.
+// The address book takes precedence over NIP-05.
+func (d NostrDNS) resolveName(ctx context.Context, name string) (context.Context, net.IP, error) {
+	var publicKey string
+	var relays []string
+	if entry, ok := d.lookupAddressBook(name); ok {
+		publicKey, relays = entry.PublicKey, entry.Relays
+	} else if identifier, ok := NIP05Identifier(name); ok && d.nip05 != nil {
+		var err error
+		if publicKey, relays, err = d.nip05.Resolve(ctx, identifier); err != nil {
+			return ctx, nil, err
+		}
+	} else {
+		return ctx, nil, fmt.Errorf("%w %q", errUnknownName, name)
+	}
+	ctx = context.WithValue(ctx, TargetPublicKey, publicKey)
+	if len(relays) > 0 {
+		ctx = context.WithValue(ctx, TargetRelays, relays)
+	}
+	return ctx, nil, nil
+}
+
+func (d NostrDNS) lookupAddressBook(name string) (AddressEntry, bool) {
+	if d.addressBook == nil {
+		return AddressEntry{}, false
+	}
+	return d.addressBook.Lookup(name)
+}
+
 type ContextKeyTargetPublicKey string
 
 const TargetPublicKey ContextKeyTargetPublicKey = "TargetPublicKey"
+
+type ContextKeyTargetRelays string
+
+// TargetRelays holds the relays of the target public key, if they differ from the configured relays.
+const TargetRelays ContextKeyTargetRelays = "TargetRelays"
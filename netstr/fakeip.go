@@ -0,0 +1,83 @@
+package netstr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// DefaultFakeIPNetwork is the network fake ip addresses are assigned from, like the VirtualAddrNetwork of Tor.
+const DefaultFakeIPNetwork = "127.192.0.0/10"
+
+var errInvalidFakeIPNetwork = errors.New("invalid fake ip network")
+
+// FakeIPPool assigns addresses of a reserved network to Nostr names, so applications without SOCKS support
+// can connect to them. The proxy maps the address back to the name when the application connects.
+// Addresses are assigned in order; once the network is exhausted, the oldest assignments are reused.
+type FakeIPPool struct {
+	prefix netip.Prefix
+
+	mu    sync.Mutex
+	next  netip.Addr
+	names map[string]netip.Addr
+	addrs map[netip.Addr]string
+}
+
+// NewFakeIPPool creates a FakeIPPool for the IPv4 network in CIDR notation, e.g. DefaultFakeIPNetwork.
+func NewFakeIPPool(network string) (*FakeIPPool, error) {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", errInvalidFakeIPNetwork, network, err)
+	}
+	if !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return nil, fmt.Errorf("%w %q: must be an IPv4 network with at least two hosts", errInvalidFakeIPNetwork, network)
+	}
+	prefix = prefix.Masked()
+	return &FakeIPPool{
+		prefix: prefix,
+		next:   prefix.Addr().Next(),
+		names:  make(map[string]netip.Addr),
+		addrs:  make(map[netip.Addr]string),
+	}, nil
+}
+
+// Map returns the address of the name and assigns one if the name has none yet.
+func (p *FakeIPPool) Map(name string) net.IP {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if addr, ok := p.names[name]; ok {
+		return addr.AsSlice()
+	}
+	addr := p.next
+	p.next = addr.Next()
+	// skip the broadcast address and start over at the first host
+	if !p.prefix.Contains(p.next.Next()) {
+		p.next = p.prefix.Addr().Next()
+	}
+	if previous, ok := p.addrs[addr]; ok {
+		delete(p.names, previous)
+	}
+	p.names[name] = addr
+	p.addrs[addr] = name
+	return addr.AsSlice()
+}
+
+// Lookup returns the name the address is assigned to.
+func (p *FakeIPPool) Lookup(ip net.IP) (string, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+	addr = addr.Unmap()
+	if !p.prefix.Contains(addr) {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name, ok := p.addrs[addr]
+	return name, ok
+}
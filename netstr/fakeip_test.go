@@ -0,0 +1,48 @@
+package netstr
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeIPPool(t *testing.T) {
+	pool, err := NewFakeIPPool(DefaultFakeIPNetwork)
+	require.NoError(t, err)
+
+	ip := pool.Map("Mint.NWS.")
+	assert.Equal(t, net.IPv4(127, 192, 0, 1).To4(), ip)
+	assert.Equal(t, ip, pool.Map("mint.nws"), "a name keeps its address")
+	assert.Equal(t, net.IPv4(127, 192, 0, 2).To4(), pool.Map("other.nws"))
+
+	name, ok := pool.Lookup(net.IPv4(127, 192, 0, 1))
+	require.True(t, ok)
+	assert.Equal(t, "mint.nws", name)
+	_, ok = pool.Lookup(net.IPv4(127, 192, 0, 3))
+	assert.False(t, ok, "unassigned address")
+	_, ok = pool.Lookup(net.IPv4(127, 0, 0, 1))
+	assert.False(t, ok, "address outside the network")
+}
+
+func TestFakeIPPoolReuse(t *testing.T) {
+	// a /30 has two usable addresses
+	pool, err := NewFakeIPPool("10.0.0.0/30")
+	require.NoError(t, err)
+	first := pool.Map("a.nws")
+	pool.Map("b.nws")
+	assert.Equal(t, first, pool.Map("c.nws"), "the oldest address is reused")
+
+	name, ok := pool.Lookup(first)
+	require.True(t, ok)
+	assert.Equal(t, "c.nws", name)
+	assert.NotEqual(t, first, pool.Map("a.nws"), "the replaced name gets a new address")
+}
+
+func TestNewFakeIPPoolInvalid(t *testing.T) {
+	for _, network := range []string{"", "127.192.0.1", "fd00::/64", "10.0.0.0/31"} {
+		_, err := NewFakeIPPool(network)
+		assert.ErrorIs(t, err, errInvalidFakeIPNetwork, network)
+	}
+}
@@ -0,0 +1,220 @@
+package netstr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/protocol"
+	"github.com/google/uuid"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// sessionQueueSize is the number of messages buffered per session until its connection reads them.
+const sessionQueueSize = 256
+
+// Subscribe subscribes to the session events sent to the public key since the given time.
+// Exit nodes and listeners receive the messages of all their sessions through this subscription.
+func Subscribe(
+	ctx context.Context, pool *nostr.SimplePool, relays []string, publicKey string, since nostr.Timestamp,
+) chan nostr.IncomingEvent {
+	return pool.SubMany(ctx, relays, nostr.Filters{
+		{
+			Kinds: []int{protocol.KindEphemeralEvent},
+			Since: &since,
+			Tags: nostr.TagMap{
+				"p": []string{publicKey},
+			},
+		},
+	})
+}
+
+// NewSession creates the connection answering the session with the given key, which the peer opened with the event.
+// Messages are sent to the peer through the relay the event was received from.
+func NewSession(
+	ctx context.Context, privateKey string, event nostr.IncomingEvent, key uuid.UUID,
+) (*NostrConnection, error) {
+	receiver, err := nip19.EncodeProfile(event.PubKey, []string{event.Relay.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode receiver: %w", err)
+	}
+	return NewConnection(
+		ctx,
+		WithPrivateKey(privateKey),
+		WithDst(receiver),
+		WithUUID(key),
+	), nil
+}
+
+// Listener accepts the sessions that entry nodes and clients open to a Nostr key, like an exit node without backend.
+// Each CONNECT message yields a NostrConnection, so servers like http.Serve can be served directly over Nostr.
+type Listener struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	pool   *nostr.SimplePool
+	// privateKey is the key sessions are opened to.
+	privateKey string
+	addr       NostrAddress
+	// events receives the messages of all sessions.
+	events chan nostr.IncomingEvent
+	// sessions holds the accepted sessions by session key, so messages can be routed to them.
+	sessions  *xsync.MapOf[string, *listenerSession]
+	accept    chan *NostrConnection
+	closeOnce sync.Once
+}
+
+// Listen subscribes to the sessions opened to the private key on the relays and returns a Listener for them.
+// Closing the listener also ends the accepted connections, since their messages arrive through its subscription.
+func Listen(ctx context.Context, privateKey string, relays []string) (net.Listener, error) {
+	listener, err := newListener(ctx, privateKey, relays)
+	if err != nil {
+		return nil, err
+	}
+	listener.events = Subscribe(listener.ctx, listener.pool, relays, listener.addr.PublicKey, nostr.Now())
+	go listener.serve()
+	return listener, nil
+}
+
+// newListener creates a Listener without subscribing to its sessions.
+func newListener(ctx context.Context, privateKey string, relays []string) (*Listener, error) {
+	publicKey, err := nostr.GetPublicKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	if _, err = (codec.Address{PublicKey: publicKey, Relays: relays}).Encode(codec.FormatNprofile); err != nil {
+		return nil, fmt.Errorf("failed to encode profile: %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Listener{
+		ctx:        ctx,
+		cancel:     cancel,
+		pool:       nostr.NewSimplePool(ctx),
+		privateKey: privateKey,
+		addr:       NostrAddress{PublicKey: publicKey, Relays: relays},
+		sessions:   xsync.NewMapOf[string, *listenerSession](),
+		accept:     make(chan *NostrConnection),
+	}, nil
+}
+
+// serve routes the incoming events until the listener is closed.
+func (l *Listener) serve() {
+	for {
+		select {
+		case event, ok := <-l.events:
+			if !ok {
+				l.Close()
+				return
+			}
+			if event.Relay == nil {
+				continue
+			}
+			l.handleEvent(event)
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleEvent opens a session for CONNECT messages and writes all other session messages to their connection.
+func (l *Listener) handleEvent(event nostr.IncomingEvent) {
+	message, err := DecryptMessage(l.privateKey, event)
+	if err != nil {
+		slog.Error("could not decrypt message", "error", err)
+		return
+	}
+	key := message.Key.String()
+	switch message.Type {
+	case protocol.MessageConnect:
+		// the same message arrives once per relay
+		if _, ok := l.sessions.Load(key); ok {
+			return
+		}
+		connection, err := NewSession(l.ctx, l.privateKey, event, message.Key)
+		if err != nil {
+			slog.Error("could not create session", "key", key, "error", err)
+			return
+		}
+		session := &listenerSession{connection: connection, queue: make(chan nostr.IncomingEvent, sessionQueueSize)}
+		l.sessions.Store(key, session)
+		go session.deliver()
+		go l.enqueue(key, connection)
+	case protocol.MessageTypeSocks5, protocol.MessageClose:
+		if session, ok := l.sessions.Load(key); ok {
+			session.push(event)
+		}
+	default:
+		slog.Debug("ignoring unsupported message", "type", message.Type, "key", key)
+	}
+}
+
+// listenerSession delivers the messages of a session to its connection in the order they arrived,
+// since they carry consecutive chunks of the stream.
+type listenerSession struct {
+	connection *NostrConnection
+	queue      chan nostr.IncomingEvent
+}
+
+// push queues the message. It blocks while the queue is full, until the connection reads or is closed.
+func (s *listenerSession) push(event nostr.IncomingEvent) {
+	select {
+	case s.queue <- event:
+	case <-s.connection.ctx.Done():
+	}
+}
+
+// deliver writes the queued messages to the connection one after another until it is closed.
+func (s *listenerSession) deliver() {
+	for {
+		select {
+		case event := <-s.queue:
+			s.connection.WriteNostrEvent(event)
+		case <-s.connection.ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue hands the connection to Accept and removes the session once the connection is closed.
+func (l *Listener) enqueue(key string, connection *NostrConnection) {
+	defer l.sessions.Delete(key)
+	select {
+	case l.accept <- connection:
+	case <-l.ctx.Done():
+		return
+	}
+	<-connection.ctx.Done()
+}
+
+// Accept waits for and returns the next session opened to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case connection := <-l.accept:
+		return connection, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting sessions and closes the relay connections.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.cancel()
+		l.pool.Relays.Range(func(url string, relay *nostr.Relay) bool {
+			if err := relay.Close(); err != nil {
+				slog.Debug("could not close relay", "url", url, "error", err)
+			}
+			return true
+		})
+	})
+	return nil
+}
+
+// Addr returns the NostrAddress of the listener. Its string form is the nprofile clients use as destination.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
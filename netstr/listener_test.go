@@ -0,0 +1,93 @@
+package netstr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/asmogo/nws/protocol"
+	"github.com/google/uuid"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionEvent creates an event of the client for the session as received from a relay.
+func sessionEvent(
+	t *testing.T, clientKey, publicKey string, messageType protocol.MessageType, key uuid.UUID, data []byte,
+) nostr.IncomingEvent {
+	t.Helper()
+	signer, err := protocol.NewEventSigner(clientKey)
+	require.NoError(t, err)
+	event, err := signer.CreateSignedEvent(publicKey, protocol.KindEphemeralEvent, nostr.Tags{nostr.Tag{"p", publicKey}},
+		protocol.WithUUID(key), protocol.WithType(messageType), protocol.WithData(data))
+	require.NoError(t, err)
+	return nostr.IncomingEvent{Event: &event, Relay: &nostr.Relay{URL: "wss://relay.example.com"}}
+}
+
+func TestListener(t *testing.T) {
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, err := nostr.GetPublicKey(privateKey)
+	require.NoError(t, err)
+	listener, err := newListener(context.Background(), privateKey, []string{"wss://relay.example.com"})
+	require.NoError(t, err)
+	listener.events = make(chan nostr.IncomingEvent, 4)
+	go listener.serve()
+
+	assert.Equal(t, "nostr", listener.Addr().Network())
+	address, _, err := ParseDestination(listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, address)
+
+	clientKey := nostr.GeneratePrivateKey()
+	key := uuid.New()
+	connect := sessionEvent(t, clientKey, publicKey, protocol.MessageConnect, key, nil)
+	listener.events <- connect
+	// the connect message of another relay does not open a second session
+	listener.events <- connect
+	listener.events <- sessionEvent(t, clientKey, publicKey, protocol.MessageTypeSocks5, key, []byte("hello"))
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, key, conn.(*NostrConnection).uuid)
+	buffer := make([]byte, 16)
+	n, err := conn.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buffer[:n]))
+	assert.Equal(t, 1, listener.sessions.Size())
+
+	require.NoError(t, listener.Close())
+	_, err = listener.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestListenerDeliversInOrder(t *testing.T) {
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, err := nostr.GetPublicKey(privateKey)
+	require.NoError(t, err)
+	listener, err := newListener(context.Background(), privateKey, []string{"wss://relay.example.com"})
+	require.NoError(t, err)
+	defer listener.Close()
+	const chunks = 50
+	listener.events = make(chan nostr.IncomingEvent, chunks+1)
+	go listener.serve()
+
+	clientKey := nostr.GeneratePrivateKey()
+	key := uuid.New()
+	listener.events <- sessionEvent(t, clientKey, publicKey, protocol.MessageConnect, key, nil)
+	var want []byte
+	for i := 0; i < chunks; i++ {
+		chunk := []byte(fmt.Sprintf("chunk %02d;", i))
+		want = append(want, chunk...)
+		listener.events <- sessionEvent(t, clientKey, publicKey, protocol.MessageTypeSocks5, key, chunk)
+	}
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
@@ -0,0 +1,278 @@
+package netstr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	// DefaultNIP05Endpoint is the url of the NIP-05 document, the domain replaces %s.
+	DefaultNIP05Endpoint = "https://%s/.well-known/nostr.json"
+	// DefaultNIP05TTL is the default time a resolved NIP-05 identifier is cached.
+	DefaultNIP05TTL = 5 * time.Minute
+	// nip05NegativeTTL is the time failed lookups are cached.
+	nip05NegativeTTL = 30 * time.Second
+	nip05Timeout     = 10 * time.Second
+	// nip05MaxSize limits the size of NIP-05 documents.
+	nip05MaxSize = 1 << 20
+	// nip05MaxCacheSize limits the number of cached identifiers.
+	nip05MaxCacheSize = 4096
+)
+
+var (
+	errInvalidIdentifier = errors.New("invalid nip-05 identifier")
+	errUnknownIdentifier = errors.New("unknown nip-05 identifier")
+	errPrivateAddress    = errors.New("nip-05 documents are not fetched from private addresses")
+)
+
+// NIP05Resolver resolves NIP-05 identifiers ("name@example.com") to a public key and relays.
+// Results are cached for the TTL, or the max-age of the response if set.
+// Identifiers are resolved on behalf of proxy clients, so domains with a port, ip addresses and localhost
+// are rejected and documents are only fetched from public addresses, unless private networks are allowed.
+type NIP05Resolver struct {
+	client   *http.Client
+	endpoint string
+	ttl      time.Duration
+	cache    *xsync.MapOf[string, nip05Result]
+	now      func() time.Time
+	// privateNetworks allows fetching documents from loopback, private and link-local addresses.
+	privateNetworks bool
+}
+
+type nip05Result struct {
+	publicKey string
+	relays    []string
+	err       error
+	expires   time.Time
+}
+
+// nip05Document is the /.well-known/nostr.json document.
+type nip05Document struct {
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays"`
+}
+
+// NIP05Option configures a NIP05Resolver.
+type NIP05Option func(*NIP05Resolver)
+
+// WithNIP05Endpoint sets the url of the NIP-05 document. The domain of the identifier replaces %s.
+// An empty endpoint keeps DefaultNIP05Endpoint.
+func WithNIP05Endpoint(endpoint string) NIP05Option {
+	return func(r *NIP05Resolver) {
+		if endpoint != "" {
+			r.endpoint = endpoint
+		}
+	}
+}
+
+// WithNIP05TTL sets the time resolved identifiers are cached if the response has no max-age.
+// A zero TTL keeps DefaultNIP05TTL.
+func WithNIP05TTL(ttl time.Duration) NIP05Option {
+	return func(r *NIP05Resolver) {
+		if ttl > 0 {
+			r.ttl = ttl
+		}
+	}
+}
+
+// WithNIP05Client sets the http client used to fetch NIP-05 documents.
+// The client replaces the check for public addresses of the default client.
+func WithNIP05Client(client *http.Client) NIP05Option {
+	return func(r *NIP05Resolver) {
+		r.client = client
+	}
+}
+
+// WithNIP05PrivateNetworks allows fetching NIP-05 documents from loopback, private and link-local addresses,
+// e.g. for tests or a NIP-05 endpoint in the local network.
+func WithNIP05PrivateNetworks() NIP05Option {
+	return func(r *NIP05Resolver) {
+		r.privateNetworks = true
+	}
+}
+
+// NewNIP05Resolver creates a NIP05Resolver. Redirects are not followed, as required by NIP-05.
+func NewNIP05Resolver(opts ...NIP05Option) *NIP05Resolver {
+	r := &NIP05Resolver{
+		endpoint: DefaultNIP05Endpoint,
+		ttl:      DefaultNIP05TTL,
+		cache:    xsync.NewMapOf[string, nip05Result](),
+		now:      time.Now,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// the address is checked after resolving, so names of public domains cannot point into the local network
+	transport.DialContext = (&net.Dialer{Timeout: nip05Timeout, Control: r.checkAddress}).DialContext
+	r.client = &http.Client{
+		Transport: transport,
+		Timeout:   nip05Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// checkAddress rejects connections to non-public addresses unless private networks are allowed.
+func (r *NIP05Resolver) checkAddress(_, address string, _ syscall.RawConn) error {
+	if r.privateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// isPublicIP reports whether the ip address is reachable on the internet.
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// validNIP05Domain reports whether documents may be fetched from the domain of an identifier.
+// Ports, ip addresses and localhost are rejected, since they address hosts instead of domains.
+func validNIP05Domain(domain string) bool {
+	if domain == "" || strings.ContainsAny(domain, "/?#@:[]") || net.ParseIP(domain) != nil {
+		return false
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	return domain != "localhost" && !strings.HasSuffix(domain, ".localhost")
+}
+
+// Resolve returns the public key and relays of the identifier.
+func (r *NIP05Resolver) Resolve(ctx context.Context, identifier string) (string, []string, error) {
+	identifier = strings.ToLower(identifier)
+	if result, ok := r.cache.Load(identifier); ok && r.now().Before(result.expires) {
+		return result.publicKey, result.relays, result.err
+	}
+	result := r.fetch(ctx, identifier)
+	// cancellations say nothing about the identifier
+	if !errors.Is(result.err, context.Canceled) && !errors.Is(result.err, context.DeadlineExceeded) {
+		r.evict()
+		r.cache.Store(identifier, result)
+	}
+	return result.publicKey, result.relays, result.err
+}
+
+// evict removes expired identifiers once the cache is full, or all of them if none expired.
+func (r *NIP05Resolver) evict() {
+	if r.cache.Size() < nip05MaxCacheSize {
+		return
+	}
+	now := r.now()
+	r.cache.Range(func(identifier string, result nip05Result) bool {
+		if !now.Before(result.expires) {
+			r.cache.Delete(identifier)
+		}
+		return true
+	})
+	if r.cache.Size() >= nip05MaxCacheSize {
+		r.cache.Clear()
+	}
+}
+
+func (r *NIP05Resolver) fetch(ctx context.Context, identifier string) nip05Result {
+	result := nip05Result{expires: r.now().Add(nip05NegativeTTL)}
+	name, domain, ok := strings.Cut(identifier, "@")
+	if !ok || name == "" || !validNIP05Domain(domain) {
+		result.err = fmt.Errorf("%w %q", errInvalidIdentifier, identifier)
+		return result
+	}
+	endpoint, err := url.Parse(fmt.Sprintf(r.endpoint, domain))
+	if err != nil {
+		result.err = fmt.Errorf("%w %q: %w", errInvalidIdentifier, identifier, err)
+		return result
+	}
+	query := endpoint.Query()
+	query.Set("name", name)
+	endpoint.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		result.err = fmt.Errorf("failed to create nip-05 request: %w", err)
+		return result
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		result.err = fmt.Errorf("failed to fetch nip-05 document of %s: %w", domain, err)
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		result.err = fmt.Errorf("failed to fetch nip-05 document of %s: %s", domain, resp.Status)
+		return result
+	}
+	var document nip05Document
+	if err = json.NewDecoder(io.LimitReader(resp.Body, nip05MaxSize)).Decode(&document); err != nil {
+		result.err = fmt.Errorf("failed to parse nip-05 document of %s: %w", domain, err)
+		return result
+	}
+	publicKey, ok := document.Names[name]
+	if !ok || !nostr.IsValidPublicKeyHex(publicKey) {
+		result.err = fmt.Errorf("%w %q", errUnknownIdentifier, identifier)
+		return result
+	}
+	result.publicKey = publicKey
+	result.relays = document.Relays[publicKey]
+	result.err = nil
+	result.expires = r.now().Add(r.cacheTTL(resp.Header.Get("Cache-Control")))
+	return result
+}
+
+// cacheTTL returns the max-age of the Cache-Control header, or the configured TTL.
+func (r *NIP05Resolver) cacheTTL(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age=")
+		if !ok {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return r.ttl
+}
+
+// NIP05Identifier returns the NIP-05 identifier of a name: either the identifier itself ("service@example.com")
+// or a name in the .nws namespace, whose first label is the local part ("service.example.com.nws").
+// Use "_" as first label for the root identifier of a domain.
+func NIP05Identifier(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if strings.Contains(name, "@") {
+		return name, true
+	}
+	if !IsAddressBookName(name) {
+		return "", false
+	}
+	local, domain, ok := strings.Cut(strings.TrimSuffix(name, AddressBookSuffix), ".")
+	if !ok || local == "" || !strings.Contains(domain, ".") {
+		return "", false
+	}
+	return local + "@" + domain, true
+}
+
+// IsNostrName reports whether the host is a name resolved by NostrDNS to an exit node:
+// an address book name or a NIP-05 identifier.
+func IsNostrName(host string) bool {
+	return IsAddressBookName(host) || strings.Contains(host, "@")
+}
@@ -0,0 +1,118 @@
+package netstr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newNIP05Server is a local stand-in for the /.well-known/nostr.json endpoint of a domain.
+func newNIP05Server(t *testing.T, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/example.com/.well-known/nostr.json" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("name") {
+		case "service":
+			w.Write([]byte(`{"names": {"service": "` + testPublicKey + `"}, "relays": {"` + testPublicKey + `": ["wss://relay.example"]}}`))
+		case "moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		default:
+			w.Write([]byte(`{"names": {}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNIP05Resolver(t *testing.T) {
+	var requests atomic.Int32
+	server := newNIP05Server(t, &requests)
+	now := time.Now()
+	resolver := NewNIP05Resolver(WithNIP05Endpoint(server.URL+"/%s/.well-known/nostr.json"), WithNIP05TTL(time.Minute),
+		WithNIP05PrivateNetworks())
+	resolver.now = func() time.Time { return now }
+
+	publicKey, relays, err := resolver.Resolve(context.Background(), "Service@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, testPublicKey, publicKey)
+	assert.Equal(t, []string{"wss://relay.example"}, relays)
+
+	// cached until the ttl expired
+	_, _, err = resolver.Resolve(context.Background(), "service@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+	now = now.Add(2 * time.Minute)
+	_, _, err = resolver.Resolve(context.Background(), "service@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	_, _, err = resolver.Resolve(context.Background(), "unknown@example.com")
+	assert.ErrorIs(t, err, errUnknownIdentifier)
+	_, _, err = resolver.Resolve(context.Background(), "unknown@example.com")
+	assert.ErrorIs(t, err, errUnknownIdentifier)
+	assert.Equal(t, int32(3), requests.Load(), "failed lookups are cached")
+
+	_, _, err = resolver.Resolve(context.Background(), "moved@example.com")
+	assert.Error(t, err, "redirects must not be followed")
+	_, _, err = resolver.Resolve(context.Background(), "invalid")
+	assert.ErrorIs(t, err, errInvalidIdentifier)
+}
+
+func TestNIP05ResolverPrivateHosts(t *testing.T) {
+	var requests atomic.Int32
+	server := newNIP05Server(t, &requests)
+	resolver := NewNIP05Resolver(WithNIP05Endpoint(server.URL + "/%s/.well-known/nostr.json"))
+	for _, identifier := range []string{
+		"x@10.0.0.1:8443", "x@example.com:8443", "x@localhost", "x@app.localhost", "x@127.0.0.1", "x@[::1]", "x@::1",
+	} {
+		_, _, err := resolver.Resolve(context.Background(), identifier)
+		assert.ErrorIs(t, err, errInvalidIdentifier, identifier)
+	}
+	// the endpoint resolves to a loopback address, which is only dialed if private networks are allowed
+	_, _, err := resolver.Resolve(context.Background(), "service@example.com")
+	assert.ErrorIs(t, err, errPrivateAddress)
+	assert.Equal(t, int32(0), requests.Load())
+}
+
+func TestNIP05Identifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		identifier string
+		ok         bool
+	}{
+		{name: "service@example.com", identifier: "service@example.com", ok: true},
+		{name: "Service.Example.com.nws", identifier: "service@example.com", ok: true},
+		{name: "_.example.com.nws", identifier: "_@example.com", ok: true},
+		{name: "mint.nws"},
+		{name: "example.com"},
+	}
+	for _, tt := range tests {
+		identifier, ok := NIP05Identifier(tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+		assert.Equal(t, tt.identifier, identifier, tt.name)
+	}
+}
+
+func TestNostrDNSResolveNIP05(t *testing.T) {
+	var requests atomic.Int32
+	server := newNIP05Server(t, &requests)
+	dns := NewNostrDNS(nil, nil, WithNIP05(NewNIP05Resolver(
+		WithNIP05Endpoint(server.URL+"/%s/.well-known/nostr.json"), WithNIP05PrivateNetworks())))
+	for _, name := range []string{"service@example.com", "service.example.com.nws"} {
+		ctx, ip, err := dns.Resolve(context.Background(), name)
+		require.NoError(t, err, name)
+		assert.Nil(t, ip)
+		assert.Equal(t, testPublicKey, ctx.Value(TargetPublicKey))
+		assert.Equal(t, []string{"wss://relay.example"}, ctx.Value(TargetRelays))
+	}
+}
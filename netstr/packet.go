@@ -0,0 +1,106 @@
+package netstr
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NostrPacketConn implements the net.PacketConn interface on top of a NostrConnection.
+// Every packet is sent as a datagram message, which carries the address of the remote peer
+// as destination: the target of the packet when writing and its source when reading.
+type NostrPacketConn struct {
+	conn *NostrConnection
+}
+
+// NewPacketConn creates a new NostrPacketConn using the given connection.
+func NewPacketConn(conn *NostrConnection) *NostrPacketConn {
+	return &NostrPacketConn{conn: conn}
+}
+
+// DialPacket creates a NostrPacketConn to the exit node of the destination.
+// Unlike DialSocks, no session is opened upfront. The exit node creates its association
+// once it receives the first datagram.
+func DialPacket(ctx context.Context, options DialOptions, config *config.EntryConfig, dst string) *NostrPacketConn {
+	connection := NewConnection(ctx,
+		WithPrivateKey(nostr.GeneratePrivateKey()),
+		WithDst(dst),
+		WithSub(),
+		WithDefaultRelays(options.targetRelays(config)),
+		WithTargetPublicKey(options.TargetPublicKey),
+		WithUUID(options.ConnectionID))
+	return NewPacketConn(connection)
+}
+
+// ReadFrom reads the next datagram. Other messages of the session are skipped.
+// The returned address is a *net.UDPAddr if the peer is an ip address and a DatagramAddr otherwise.
+func (c *NostrPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		message, err := c.conn.readMessage()
+		if err != nil {
+			return 0, nil, err
+		}
+		if message == nil || message.Type != protocol.MessageDatagram {
+			continue
+		}
+		n := copy(p, message.Data)
+		return n, ParseDatagramAddr(message.Destination), nil
+	}
+}
+
+// WriteTo sends a datagram to the given address through the exit node.
+func (c *NostrPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.conn.writeMessage(p, protocol.MessageDatagram, addr.String())
+}
+
+// Close closes the underlying connection, which tells the peer to release the association.
+func (c *NostrPacketConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *NostrPacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *NostrPacketConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *NostrPacketConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *NostrPacketConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+// DatagramAddr is the "host:port" address of a datagram peer that is not an ip address,
+// e.g. a domain that is resolved by the exit node.
+type DatagramAddr string
+
+func (a DatagramAddr) Network() string {
+	return "udp"
+}
+
+func (a DatagramAddr) String() string {
+	return string(a)
+}
+
+// ParseDatagramAddr parses a "host:port" datagram address without resolving it.
+func ParseDatagramAddr(address string) net.Addr {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return DatagramAddr(address)
+	}
+	ip := net.ParseIP(host)
+	portNumber, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return DatagramAddr(address)
+	}
+	return &net.UDPAddr{IP: ip, Port: portNumber}
+}
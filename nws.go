@@ -0,0 +1,220 @@
+// Package nws is a client library for Nostr Web Services. A Dialer connects to services behind exit nodes
+// directly over Nostr, without running an entry node:
+//
+//	dialer := nws.NewDialer(nws.WithRelays("wss://relay.damus.io"))
+//	defer dialer.Close()
+//	conn, err := dialer.DialContext(ctx, "tcp", "nprofile1...:443")
+//
+// Destinations are .nostr domains, npubs, nprofiles, address book names and NIP-05 identifiers.
+// Other destinations are relayed through the exit node set with WithExit or a public exit node.
+package nws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/protocol"
+	"github.com/google/uuid"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	errUnsupportedNetwork = errors.New("unsupported network")
+	errNoExit             = errors.New("no exit node found")
+)
+
+// Dialer connects to NWS destinations. It is safe for concurrent use.
+type Dialer struct {
+	pool        *nostr.SimplePool
+	ownPool     bool
+	relays      []string
+	exit        string
+	addressBook *netstr.AddressBook
+	nip05       *netstr.NIP05Resolver
+	resolver    *netstr.NostrDNS
+	// dial creates the Nostr connection, it is replaced in tests.
+	dial func(ctx context.Context, options netstr.DialOptions, addr string) (net.Conn, error)
+}
+
+// Option configures a Dialer.
+type Option func(*Dialer)
+
+// WithRelays sets the relays used for destinations without relays of their own. Defaults to the default relays.
+func WithRelays(relays ...string) Option {
+	return func(d *Dialer) {
+		d.relays = relays
+	}
+}
+
+// WithPool sets the relay pool. By default, the Dialer creates its own pool, which is closed by Close.
+func WithPool(pool *nostr.SimplePool) Option {
+	return func(d *Dialer) {
+		d.pool = pool
+	}
+}
+
+// WithExit sets the exit node (npub, nprofile or hex public key) of destinations outside the Nostr namespace.
+// By default, a public exit node announced on the relays is used.
+func WithExit(exit string) Option {
+	return func(d *Dialer) {
+		d.exit = exit
+	}
+}
+
+// WithAddressBook resolves address book names like "mint.nws".
+func WithAddressBook(book *netstr.AddressBook) Option {
+	return func(d *Dialer) {
+		d.addressBook = book
+	}
+}
+
+// WithNIP05 sets the resolver of NIP-05 identifiers. Defaults to a resolver with the default settings.
+func WithNIP05(resolver *netstr.NIP05Resolver) Option {
+	return func(d *Dialer) {
+		d.nip05 = resolver
+	}
+}
+
+// NewDialer creates a Dialer.
+func NewDialer(opts ...Option) *Dialer {
+	d := &Dialer{dial: netstr.Dial}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.pool == nil {
+		d.pool, d.ownPool = nostr.NewSimplePool(context.Background()), true
+	}
+	if len(d.relays) == 0 {
+		d.relays = config.DefaultRelays
+	}
+	if d.nip05 == nil {
+		d.nip05 = netstr.NewNIP05Resolver()
+	}
+	resolverOpts := []netstr.NostrDNSOption{netstr.WithNIP05(d.nip05)}
+	if d.addressBook != nil {
+		resolverOpts = append(resolverOpts, netstr.WithAddressBook(d.addressBook))
+	}
+	d.resolver = netstr.NewNostrDNS(d.pool, d.relays, resolverOpts...)
+	return d
+}
+
+// Dial connects to the address on the named network, see DialContext.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address ("host:port") on the named network. Only tcp is supported.
+// The connection lives until it is closed, the context only limits establishing it.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, _, err := d.dialTarget(ctx, network, address)
+	return conn, err
+}
+
+// dialTarget connects to the address and returns the options used to reach the exit node.
+func (d *Dialer) dialTarget(ctx context.Context, network, address string) (net.Conn, netstr.DialOptions, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, netstr.DialOptions{}, fmt.Errorf("failed to dial %s: %w %s", address, errUnsupportedNetwork, network)
+	}
+	options, destination, err := d.target(ctx, address)
+	if err != nil {
+		return nil, netstr.DialOptions{}, fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	options.Pool = d.pool
+	options.ConnectionID = uuid.New()
+	options.MessageType = protocol.MessageConnect
+	// the context limits publishing the request, netstr.Dial detaches the lifetime of the connection
+	conn, err := d.dial(ctx, options, destination)
+	if err != nil {
+		return nil, netstr.DialOptions{}, fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	return conn, options, nil
+}
+
+// target returns the exit node of the address and the destination sent to it.
+// Nostr destinations are sent as .nostr domain, which is what exit nodes expect.
+func (d *Dialer) target(ctx context.Context, address string) (netstr.DialOptions, string, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return netstr.DialOptions{}, "", err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return netstr.DialOptions{}, "", fmt.Errorf("invalid port %q", portString)
+	}
+	var exit codec.Address
+	switch {
+	case codec.IsAddress(host):
+		if exit, err = codec.ParseAddress(host); err != nil {
+			return netstr.DialOptions{}, "", err
+		}
+	case netstr.IsNostrName(host):
+		if exit, err = d.resolve(ctx, host); err != nil {
+			return netstr.DialOptions{}, "", err
+		}
+	default:
+		if exit, err = d.clearnetExit(ctx, host); err != nil {
+			return netstr.DialOptions{}, "", err
+		}
+		return d.options(exit), address, nil
+	}
+	exit.Port = uint16(port)
+	destination, err := exit.Encode(codec.FormatDomain)
+	if err != nil {
+		return netstr.DialOptions{}, "", err
+	}
+	return d.options(exit), destination, nil
+}
+
+// options returns the dial options of the exit node.
+func (d *Dialer) options(exit codec.Address) netstr.DialOptions {
+	relays := exit.Relays
+	if len(relays) == 0 {
+		relays = d.relays
+	}
+	return netstr.DialOptions{TargetPublicKey: exit.PublicKey, TargetRelays: relays}
+}
+
+// resolve returns the exit node of an address book name or NIP-05 identifier.
+func (d *Dialer) resolve(ctx context.Context, host string) (codec.Address, error) {
+	ctx, _, err := d.resolver.Resolve(ctx, host)
+	if err != nil {
+		return codec.Address{}, err
+	}
+	publicKey, ok := ctx.Value(netstr.TargetPublicKey).(string)
+	if !ok {
+		return codec.Address{}, fmt.Errorf("%w for %s", errNoExit, host)
+	}
+	relays, _ := ctx.Value(netstr.TargetRelays).([]string)
+	return codec.Address{PublicKey: publicKey, Relays: relays}, nil
+}
+
+// clearnetExit returns the configured exit node or a public exit node.
+func (d *Dialer) clearnetExit(ctx context.Context, host string) (codec.Address, error) {
+	if d.exit == "" {
+		return d.resolve(ctx, host)
+	}
+	if nostr.IsValidPublicKeyHex(d.exit) {
+		return codec.Address{PublicKey: d.exit}, nil
+	}
+	return codec.ParseAddress(d.exit)
+}
+
+// Close closes the relay connections of the pool, unless it was set with WithPool.
+func (d *Dialer) Close() error {
+	if !d.ownPool {
+		return nil
+	}
+	d.pool.Relays.Range(func(_ string, relay *nostr.Relay) bool {
+		relay.Close()
+		return true
+	})
+	return nil
+}
@@ -0,0 +1,171 @@
+package nws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func testPublicKey(t *testing.T) string {
+	t.Helper()
+	publicKey, err := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey
+}
+
+// recordingDialer returns a Dialer that records the dial options and destination instead of dialing.
+func recordingDialer(t *testing.T, opts ...Option) (*Dialer, *netstr.DialOptions, *string) {
+	t.Helper()
+	d := NewDialer(append([]Option{WithRelays("wss://default.example")}, opts...)...)
+	t.Cleanup(func() { d.Close() })
+	var options netstr.DialOptions
+	var destination string
+	d.dial = func(_ context.Context, o netstr.DialOptions, addr string) (net.Conn, error) {
+		options, destination = o, addr
+		client, server := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		return client, nil
+	}
+	return d, &options, &destination
+}
+
+func TestDialContext(t *testing.T) {
+	publicKey := testPublicKey(t)
+	exit := testPublicKey(t)
+	relays := []string{"wss://relay.example"}
+	domain, err := codec.Address{PublicKey: publicKey, Relays: relays, Port: 443}.Encode(codec.FormatDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nprofile, err := codec.Address{PublicKey: publicKey, Relays: relays}.Encode(codec.FormatNprofile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, err := codec.Address{PublicKey: publicKey}.Encode(codec.FormatNpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	npubDomain, err := codec.Address{PublicKey: publicKey, Port: 443}.Encode(codec.FormatDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := netstr.LoadAddressBook(filepath.Join(t.TempDir(), "addressbook.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = book.Add("mint.nws", nprofile); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		address         string
+		wantDestination string
+		wantPublicKey   string
+		wantRelays      []string
+	}{
+		{"nprofile", nprofile + ":443", domain, publicKey, relays},
+		{"domain", domain, domain, publicKey, relays},
+		{"npub uses default relays", npub + ":443", npubDomain, publicKey, []string{"wss://default.example"}},
+		{"address book", "mint.nws:443", domain, publicKey, relays},
+		{"clearnet via exit", "example.com:80", "example.com:80", exit, []string{"wss://default.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, options, destination := recordingDialer(t, WithAddressBook(book), WithExit(exit))
+			conn, err := d.DialContext(context.Background(), "tcp", tt.address)
+			if err != nil {
+				t.Fatalf("DialContext() error = %v", err)
+			}
+			conn.Close()
+			if *destination != tt.wantDestination {
+				t.Errorf("destination = %s, want %s", *destination, tt.wantDestination)
+			}
+			if options.TargetPublicKey != tt.wantPublicKey {
+				t.Errorf("TargetPublicKey = %s, want %s", options.TargetPublicKey, tt.wantPublicKey)
+			}
+			if len(options.TargetRelays) != len(tt.wantRelays) || options.TargetRelays[0] != tt.wantRelays[0] {
+				t.Errorf("TargetRelays = %v, want %v", options.TargetRelays, tt.wantRelays)
+			}
+			if options.MessageType != protocol.MessageConnect {
+				t.Errorf("MessageType = %s, want %s", options.MessageType, protocol.MessageConnect)
+			}
+		})
+	}
+}
+
+func TestDialContextErrors(t *testing.T) {
+	d, _, _ := recordingDialer(t, WithExit(testPublicKey(t)))
+	if _, err := d.DialContext(context.Background(), "udp", "example.com:53"); !errors.Is(err, errUnsupportedNetwork) {
+		t.Errorf("udp: error = %v, want %v", err, errUnsupportedNetwork)
+	}
+	for _, address := range []string{"example.com", "example.com:http", "npub1invalid:443"} {
+		if _, err := d.DialContext(context.Background(), "tcp", address); err == nil {
+			t.Errorf("%s: expected error", address)
+		}
+	}
+}
+
+func TestDialContextDeadline(t *testing.T) {
+	d, _, _ := recordingDialer(t, WithExit(testPublicKey(t)))
+	var deadline time.Time
+	dial := d.dial
+	d.dial = func(ctx context.Context, o netstr.DialOptions, addr string) (net.Conn, error) {
+		deadline, _ = ctx.Deadline()
+		return dial(ctx, o, addr)
+	}
+	want := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), want)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	conn.Close()
+	if !deadline.Equal(want) {
+		t.Errorf("dial deadline = %v, want %v", deadline, want)
+	}
+}
+
+func TestRoundTripper(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.Host)
+	}))
+	defer backend.Close()
+	d := NewDialer(WithRelays("wss://default.example"), WithExit(testPublicKey(t)))
+	defer d.Close()
+	var destination string
+	d.dial = func(ctx context.Context, _ netstr.DialOptions, addr string) (net.Conn, error) {
+		destination = addr
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", backend.Listener.Addr().String())
+	}
+	client := &http.Client{Transport: d.RoundTripper()}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello example.com" {
+		t.Errorf("body = %q, want %q", body, "hello example.com")
+	}
+	if destination != "example.com:80" {
+		t.Errorf("destination = %s, want example.com:80", destination)
+	}
+}
@@ -13,6 +13,12 @@ const (
 	MessageTypeSocks5     = MessageType("SOCKS5RESPONSE")
 	MessageConnect        = MessageType("CONNECT")
 	MessageConnectReverse = MessageType("CONNECTR")
+	// MessageBind asks the exit node to listen for a single inbound connection (SOCKS5 BIND).
+	MessageBind = MessageType("BIND")
+	// MessageDatagram carries a single udp packet. Its destination is the address of the remote peer.
+	MessageDatagram = MessageType("DATAGRAM")
+	// MessageClose tells the peer that the session was closed and no more data will be sent.
+	MessageClose = MessageType("CLOSE")
 )
 
 type Message struct {
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/socks5"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// fakeIPTTL is the TTL of fake ip answers. It is short, so clients do not keep addresses that were reused.
+	fakeIPTTL     = 60
+	dnsTimeout    = 10 * time.Second
+	maxDNSMessage = 65535
+)
+
+// dnsServer answers queries for Nostr names (.nostr domains, npubs and address book names) with addresses
+// of a fake ip pool, so applications without SOCKS support can reach them through the proxy.
+// All other queries are forwarded to the upstream DNS server.
+type dnsServer struct {
+	upstream string
+	resolver socks5.NameResolver
+	fakeIPs  *netstr.FakeIPPool
+}
+
+// serve answers queries received on conn until it is closed.
+func (d *dnsServer) serve(conn net.PacketConn) error {
+	buf := make([]byte, maxDNSMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read dns query: %w", err)
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+			defer cancel()
+			response, err := d.answer(ctx, query)
+			if err != nil {
+				slog.Debug("failed to answer dns query", "client", addr, "error", err)
+				return
+			}
+			if _, err = conn.WriteTo(response, addr); err != nil {
+				slog.Debug("failed to send dns response", "client", addr, "error", err)
+			}
+		}()
+	}
+}
+
+// answer returns the response to the query.
+func (d *dnsServer) answer(ctx context.Context, query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dns query: %w", err)
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dns question: %w", err)
+	}
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	if !codec.IsAddress(name) && !netstr.IsNostrName(name) {
+		return d.forward(ctx, query)
+	}
+	response := dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+	}
+	if err = d.validate(ctx, name); err != nil {
+		slog.Debug("unknown nostr name", "name", name, "error", err)
+		response.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, response)
+	builder.EnableCompression()
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = builder.Question(question); err != nil {
+		return nil, err
+	}
+	// other record types get an empty answer
+	if response.RCode == dnsmessage.RCodeSuccess && question.Class == dnsmessage.ClassINET &&
+		(question.Type == dnsmessage.TypeA || question.Type == dnsmessage.TypeALL) {
+		if err = builder.StartAnswers(); err != nil {
+			return nil, err
+		}
+		ip := d.fakeIPs.Map(name)
+		err = builder.AResource(
+			dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: fakeIPTTL},
+			dnsmessage.AResource{A: [4]byte(ip.To4())},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// validate checks that the name is a valid nostr address or resolves to an exit node.
+func (d *dnsServer) validate(ctx context.Context, name string) error {
+	if codec.IsAddress(name) {
+		_, err := codec.ParseAddress(name)
+		return err
+	}
+	_, _, err := d.resolver.Resolve(ctx, name)
+	return err
+}
+
+// forward sends the query to the upstream DNS server and returns its response.
+func (d *dnsServer) forward(ctx context.Context, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", d.upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream dns server: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to forward dns query: %w", err)
+	}
+	buf := make([]byte, maxDNSMessage)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstream dns response: %w", err)
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/netstr"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const dnsTestPublicKey = "452ebe58d395b1b196a9b8c82b038b6895cb02b683d0c253a955068dba1facd0"
+
+// addressBookResolver resolves "mint.nws" only.
+type addressBookResolver struct{}
+
+func (addressBookResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	if name != "mint.nws" {
+		return ctx, nil, errors.New("unknown name")
+	}
+	return ctx, nil, nil
+}
+
+func newDNSQuery(t *testing.T, name string, qtype dnsmessage.Type) []byte {
+	t.Helper()
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name + "."), Type: qtype, Class: dnsmessage.ClassINET})
+	query, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func newTestDNSServer(t *testing.T, upstream string) *dnsServer {
+	t.Helper()
+	fakeIPs, err := netstr.NewFakeIPPool(netstr.DefaultFakeIPNetwork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dnsServer{upstream: upstream, resolver: addressBookResolver{}, fakeIPs: fakeIPs}
+}
+
+func TestDNSServerNostrNames(t *testing.T) {
+	domain, err := codec.Address{PublicKey: dnsTestPublicKey}.Encode(codec.FormatDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, _ := codec.Address{PublicKey: dnsTestPublicKey}.Encode(codec.FormatNpub)
+	d := newTestDNSServer(t, "")
+	tests := []struct {
+		name    string
+		qtype   dnsmessage.Type
+		rcode   dnsmessage.RCode
+		answers int
+	}{
+		{name: domain, qtype: dnsmessage.TypeA, answers: 1},
+		{name: npub, qtype: dnsmessage.TypeA, answers: 1},
+		{name: "mint.nws", qtype: dnsmessage.TypeA, answers: 1},
+		{name: "mint.nws", qtype: dnsmessage.TypeAAAA},
+		{name: "unknown.nws", qtype: dnsmessage.TypeA, rcode: dnsmessage.RCodeNameError},
+		{name: "invalid.nostr", qtype: dnsmessage.TypeA, rcode: dnsmessage.RCodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.qtype.String(), func(t *testing.T) {
+			response, err := d.answer(context.Background(), newDNSQuery(t, tt.name, tt.qtype))
+			if err != nil {
+				t.Fatalf("answer() error = %v", err)
+			}
+			var msg dnsmessage.Message
+			if err = msg.Unpack(response); err != nil {
+				t.Fatal(err)
+			}
+			if msg.ID != 42 || msg.RCode != tt.rcode || len(msg.Answers) != tt.answers {
+				t.Fatalf("answer() = id %d, %v with %d answers, want %v with %d answers",
+					msg.ID, msg.RCode, len(msg.Answers), tt.rcode, tt.answers)
+			}
+			if tt.answers == 0 {
+				return
+			}
+			ip := net.IP(msg.Answers[0].Body.(*dnsmessage.AResource).A[:])
+			if name, ok := d.fakeIPs.Lookup(ip); !ok || name != tt.name {
+				t.Errorf("fake ip %v maps to %q, want %q", ip, name, tt.name)
+			}
+		})
+	}
+}
+
+func TestDNSServerForward(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, maxDNSMessage)
+		n, addr, err := upstream.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err = msg.Unpack(buf[:n]); err != nil {
+			return
+		}
+		msg.Response = true
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}},
+		}}
+		response, _ := msg.Pack()
+		upstream.WriteTo(response, addr)
+	}()
+
+	d := newTestDNSServer(t, upstream.LocalAddr().String())
+	response, err := d.answer(context.Background(), newDNSQuery(t, "example.com", dnsmessage.TypeA))
+	if err != nil {
+		t.Fatalf("answer() error = %v", err)
+	}
+	var msg dnsmessage.Message
+	if err = msg.Unpack(response); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Body.(*dnsmessage.AResource).A != [4]byte{93, 184, 216, 34} {
+		t.Errorf("answer() = %v, want the upstream response", msg.Answers)
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/socks5"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var errInvalidForward = errors.New("invalid forward")
+
+// Forward tunnels the connections of a local TCP address to a NWS destination, similar to ssh -L.
+type Forward struct {
+	// Listen is the local address, e.g. "127.0.0.1:5432".
+	Listen string `json:"listen"`
+	// To is the destination with port: a .nostr domain, npub, nprofile, address book name or NIP-05 name.
+	To string `json:"to"`
+}
+
+// LoadForwards loads forwards from the JSON file at path:
+//
+//	[
+//	  {"listen": "127.0.0.1:5432", "to": "nprofile1...:5432"},
+//	  {"listen": "127.0.0.1:8080", "to": "mint.nws:80"}
+//	]
+func LoadForwards(path string) ([]Forward, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forwards file: %w", err)
+	}
+	var forwards []Forward
+	if err = json.Unmarshal(data, &forwards); err != nil {
+		return nil, fmt.Errorf("failed to parse forwards file %s: %w", path, err)
+	}
+	return forwards, nil
+}
+
+// parse validates the forward. npub and nprofile destinations are encoded as .nostr domain,
+// which is what exit nodes expect as destination.
+func (f Forward) parse() (Forward, error) {
+	if _, _, err := net.SplitHostPort(f.Listen); err != nil {
+		return Forward{}, fmt.Errorf("%w: listen address %q: %w", errInvalidForward, f.Listen, err)
+	}
+	host, port, err := net.SplitHostPort(f.To)
+	if err != nil {
+		return Forward{}, fmt.Errorf("%w: destination %q: %w", errInvalidForward, f.To, err)
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return Forward{}, fmt.Errorf("%w: destination %q: invalid port", errInvalidForward, f.To)
+	}
+	if codec.IsAddress(host) {
+		address, err := codec.ParseAddress(f.To)
+		if err != nil {
+			return Forward{}, fmt.Errorf("%w: %w", errInvalidForward, err)
+		}
+		if f.To, err = address.Encode(codec.FormatDomain); err != nil {
+			return Forward{}, fmt.Errorf("%w: %w", errInvalidForward, err)
+		}
+	}
+	return f, nil
+}
+
+// Forwarder serves forwards. The destinations are resolved and dialed like destinations of SOCKS5 clients.
+type Forwarder struct {
+	pool     *nostr.SimplePool
+	server   *socks5.Server
+	forwards []Forward
+}
+
+// NewForwarder creates a Forwarder for the forwards. It returns an error if a forward is invalid.
+func NewForwarder(ctx context.Context, config *config.EntryConfig, forwards []Forward) (*Forwarder, error) {
+	if len(forwards) == 0 {
+		return nil, fmt.Errorf("%w: no forwards configured", errInvalidForward)
+	}
+	forwarder := &Forwarder{pool: nostr.NewSimplePool(ctx)}
+	for _, forward := range forwards {
+		parsed, err := forward.parse()
+		if err != nil {
+			return nil, err
+		}
+		forwarder.forwards = append(forwarder.forwards, parsed)
+	}
+	resolver, err := newResolver(ctx, forwarder.pool, config)
+	if err != nil {
+		return nil, err
+	}
+	server, err := socks5.New(&socks5.Config{
+		Resolver: resolver,
+		Rewriter: nameRewriter{resolver: resolver},
+	}, forwarder.pool, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socks server: %w", err)
+	}
+	forwarder.server = server
+	return forwarder, nil
+}
+
+// Start listens on all forward addresses and blocks until one of them fails or the forwarder is shut down.
+func (f *Forwarder) Start() error {
+	listeners := make([]net.Listener, 0, len(f.forwards))
+	for _, forward := range f.forwards {
+		ln, err := net.Listen(networkTCP, forward.Listen)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", forward.Listen, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	errCh := make(chan error, len(f.forwards))
+	for i, forward := range f.forwards {
+		slog.Info("forwarding", "listen", listeners[i].Addr(), "to", forward.To)
+		go func(ln net.Listener, forward Forward) {
+			errCh <- f.server.ServeHandler(ln, func(ctx context.Context, conn net.Conn) error {
+				return f.forward(ctx, conn, forward)
+			})
+		}(listeners[i], forward)
+	}
+	for range f.forwards {
+		err := <-errCh
+		if err != nil && !errors.Is(err, socks5.ErrServerClosed) {
+			return fmt.Errorf("forward failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// forward tunnels a single client connection to the destination of the forward.
+func (f *Forwarder) forward(ctx context.Context, conn net.Conn, forward Forward) error {
+	authContext := &socks5.AuthContext{Method: socks5.NoAuth, Payload: map[string]string{}}
+	req, err := newConnectRequest(conn, forward.To, authContext)
+	if err != nil {
+		return err
+	}
+	target, err := f.server.DialContext(ctx, req)
+	if err != nil {
+		slog.Error("failed to forward connection", "client", conn.RemoteAddr(), "to", forward.To, "error", err)
+		return err
+	}
+	defer target.Close()
+	return f.server.ProxyConnect(ctx, req, conn, target)
+}
+
+// Shutdown stops accepting new connections, waits for active connections to finish until the context is done
+// and closes the relay connections afterwards.
+func (f *Forwarder) Shutdown(ctx context.Context) error {
+	err := f.server.Shutdown(ctx)
+	closeRelays(f.pool)
+	return err
+}
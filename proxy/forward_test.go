@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/socks5"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestForwardParse(t *testing.T) {
+	npub, _ := codec.Address{PublicKey: dnsTestPublicKey}.Encode(codec.FormatNpub)
+	domain, _ := codec.Address{PublicKey: dnsTestPublicKey, Port: 5432}.Encode(codec.FormatDomain)
+
+	forward, err := Forward{Listen: "127.0.0.1:5432", To: npub + ":5432"}.parse()
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if forward.To != domain {
+		t.Errorf("parse() destination = %s, want %s", forward.To, domain)
+	}
+	forward, err = Forward{Listen: "127.0.0.1:8080", To: "mint.nws:80"}.parse()
+	if err != nil || forward.To != "mint.nws:80" {
+		t.Errorf("parse() = %v, %v, want the name unchanged", forward, err)
+	}
+	for _, invalid := range []Forward{
+		{Listen: "5432", To: npub + ":5432"},
+		{Listen: "127.0.0.1:5432", To: npub},
+		{Listen: "127.0.0.1:5432", To: npub + ":postgres"},
+		{Listen: "127.0.0.1:5432", To: "npub1invalid:5432"},
+	} {
+		if _, err = invalid.parse(); !errors.Is(err, errInvalidForward) {
+			t.Errorf("parse(%v) error = %v, want %v", invalid, err, errInvalidForward)
+		}
+	}
+}
+
+func TestLoadForwards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forwards.json")
+	data := `[{"listen": "127.0.0.1:5432", "to": "db.nws:5432"}, {"listen": "127.0.0.1:8080", "to": "mint.nws:80"}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	forwards, err := LoadForwards(path)
+	if err != nil {
+		t.Fatalf("LoadForwards() error = %v", err)
+	}
+	if len(forwards) != 2 || forwards[1] != (Forward{Listen: "127.0.0.1:8080", To: "mint.nws:80"}) {
+		t.Errorf("LoadForwards() = %v", forwards)
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	dialed := make(chan string, 1)
+	server, err := socks5.New(&socks5.Config{
+		Resolver: staticResolver{},
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed <- addr
+			var d net.Dialer
+			return d.DialContext(ctx, network, backend.Addr().String())
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder := &Forwarder{pool: &nostr.SimplePool{}, server: server}
+	forward := Forward{Listen: ln.Addr().String(), To: "service.nostr:5432"}
+	go server.ServeHandler(ln, func(ctx context.Context, conn net.Conn) error {
+		return forwarder.forward(ctx, conn, forward)
+	})
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = io.WriteString(client, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read = %q, %v, want ping", buf, err)
+	}
+	if addr := <-dialed; !strings.HasSuffix(addr, ":5432") {
+		t.Errorf("dialed %s, want port 5432", addr)
+	}
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/asmogo/nws/socks5"
+)
+
+const proxyAuthRealm = `Basic realm="nws"`
+
+// httpProxy is a HTTP proxy frontend of the entry node.
+// It handles CONNECT requests and plain HTTP requests with absolute URIs.
+// Destinations are dialed through the socks server, so resolution, rules and the Nostr transport
+// are the same as for SOCKS5 clients.
+type httpProxy struct {
+	server *socks5.Server
+	// credentials enable proxy authentication if set.
+	credentials socks5.CredentialStore
+}
+
+// serveConn serves the requests of a single client connection.
+func (p *httpProxy) serveConn(ctx context.Context, conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	// the transport keeps connections to destinations of plain HTTP requests alive for this client only
+	var authContext *socks5.AuthContext
+	// authorization is the Proxy-Authorization header accepted on this connection. Tokens are accepted once,
+	// so later requests of the connection presenting the same header are not checked again.
+	var authorization []string
+	transport := &http.Transport{
+		DialContext: func(_ context.Context, _, addr string) (net.Conn, error) {
+			return p.dial(ctx, conn, addr, authContext)
+		},
+	}
+	defer transport.CloseIdleConnections()
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read http proxy request: %w", err)
+		}
+		if authContext == nil || !slices.Equal(authorization, req.Header.Values("Proxy-Authorization")) {
+			var ok bool
+			if authContext, ok = p.authenticate(req); !ok {
+				return writeStatus(conn, http.StatusProxyAuthRequired, http.Header{"Proxy-Authenticate": {proxyAuthRealm}})
+			}
+			authorization = req.Header.Values("Proxy-Authorization")
+		}
+		if req.Method == http.MethodConnect {
+			return p.handleConnect(ctx, conn, reader, req, authContext)
+		}
+		if err = p.handleHTTP(conn, req, transport); err != nil {
+			return err
+		}
+		if req.Close {
+			return nil
+		}
+	}
+}
+
+// authenticate checks the Proxy-Authorization header against the credentials.
+func (p *httpProxy) authenticate(req *http.Request) (*socks5.AuthContext, bool) {
+	if p.credentials == nil {
+		return &socks5.AuthContext{Method: socks5.NoAuth, Payload: map[string]string{}}, true
+	}
+	// reuse the basic auth parser of net/http
+	authRequest := &http.Request{Header: http.Header{"Authorization": req.Header.Values("Proxy-Authorization")}}
+	user, password, ok := authRequest.BasicAuth()
+	if !ok || !p.credentials.Valid(user, password) {
+		return nil, false
+	}
+	return &socks5.AuthContext{Method: socks5.UserPassAuth, Payload: map[string]string{"Username": user}}, true
+}
+
+// handleConnect dials the requested destination and tunnels the client connection to it.
+func (p *httpProxy) handleConnect(
+	ctx context.Context, conn net.Conn, reader *bufio.Reader, req *http.Request, authContext *socks5.AuthContext,
+) error {
+	socksRequest, err := newConnectRequest(conn, req.Host, authContext)
+	if err != nil {
+		return errors.Join(err, writeStatus(conn, http.StatusBadRequest, nil))
+	}
+	target, err := p.server.DialContext(ctx, socksRequest)
+	if err != nil {
+		return errors.Join(err, writeStatus(conn, dialErrorStatus(err), nil))
+	}
+	defer target.Close()
+	if _, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return fmt.Errorf("failed to write connect response: %w", err)
+	}
+	// the client may have sent data before it received the response
+	client := &bufferedConn{Conn: conn, reader: reader}
+	return p.server.ProxyConnect(ctx, socksRequest, client, target)
+}
+
+// handleHTTP forwards a plain HTTP request with an absolute URI and writes the response to the client.
+func (p *httpProxy) handleHTTP(conn net.Conn, req *http.Request, transport *http.Transport) error {
+	if !req.URL.IsAbs() {
+		return errors.Join(fmt.Errorf("http proxy request without absolute uri: %s", req.RequestURI),
+			writeStatus(conn, http.StatusBadRequest, nil))
+	}
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Del("Proxy-Connection")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		// the response closes the connection, so the session ends here
+		return errors.Join(fmt.Errorf("http proxy request to %s failed: %w", req.URL.Redacted(), err),
+			writeStatus(conn, dialErrorStatus(err), nil))
+	}
+	defer resp.Body.Close()
+	if err = resp.Write(conn); err != nil {
+		return fmt.Errorf("failed to write http proxy response: %w", err)
+	}
+	return nil
+}
+
+// dial connects to addr through the socks server on behalf of the client.
+func (p *httpProxy) dial(ctx context.Context, conn net.Conn, addr string, authContext *socks5.AuthContext) (net.Conn, error) {
+	req, err := newConnectRequest(conn, addr, authContext)
+	if err != nil {
+		return nil, err
+	}
+	return p.server.DialContext(ctx, req)
+}
+
+// newConnectRequest creates the socks CONNECT request for a "host:port" destination.
+func newConnectRequest(conn net.Conn, hostPort string, authContext *socks5.AuthContext) (*socks5.Request, error) {
+	host, portString, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %w", hostPort, err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination port %q: %w", hostPort, err)
+	}
+	dest := &socks5.AddrSpec{Port: port}
+	if ip := net.ParseIP(host); ip != nil {
+		dest.IP = ip
+	} else {
+		dest.FQDN = host
+	}
+	req := &socks5.Request{Command: socks5.ConnectCommand, AuthContext: authContext, DestAddr: dest}
+	if client, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		req.RemoteAddr = &socks5.AddrSpec{IP: client.IP, Port: client.Port}
+	}
+	return req, nil
+}
+
+// dialErrorStatus maps dial errors to the response status.
+func dialErrorStatus(err error) int {
+	if errors.Is(err, socks5.ErrRuleBlocked) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// writeStatus writes an empty response with the given status and closes the connection afterwards.
+func writeStatus(conn net.Conn, status int, header http.Header) error {
+	resp := &http.Response{
+		StatusCode: status,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Close:      true,
+	}
+	if err := resp.Write(conn); err != nil {
+		return fmt.Errorf("failed to write http proxy response: %w", err)
+	}
+	return nil
+}
+
+// bufferedConn reads from the buffered reader of a connection before reading from the connection itself.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
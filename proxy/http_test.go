@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/socks5"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// staticResolver resolves every name to the loopback address.
+type staticResolver struct{}
+
+func (staticResolver) Resolve(ctx context.Context, _ string) (context.Context, net.IP, error) {
+	return ctx, net.IPv4(127, 0, 0, 1), nil
+}
+
+// startHTTPProxy starts a http proxy frontend that dials every destination to the given backend.
+func startHTTPProxy(t *testing.T, backend string, credentials socks5.CredentialStore) string {
+	t.Helper()
+	server, err := socks5.New(&socks5.Config{
+		Resolver: staticResolver{},
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, backend)
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontend := &httpProxy{server: server, credentials: credentials}
+	go server.ServeHandler(ln, frontend.serveConn)
+	t.Cleanup(func() {
+		// idle keep-alive connections of the clients are closed once the deadline is reached
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return ln.Addr().String()
+}
+
+func newBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.Host)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestHTTPProxyPlain(t *testing.T) {
+	backend := newBackend(t)
+	proxyAddr := startHTTPProxy(t, backend.Listener.Addr().String(), nil)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}}
+	resp, err := client.Get("http://service.nostr/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello service.nostr" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestHTTPProxyConnect(t *testing.T) {
+	backend := newBackend(t)
+	proxyAddr := startHTTPProxy(t, backend.Listener.Addr().String(), socks5.StaticCredentials{"user": "secret"})
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the request to the backend is sent right away, before the connect response was read
+	fmt.Fprint(conn, "CONNECT service.nostr:80 HTTP/1.1\r\nHost: service.nostr:80\r\n"+
+		"Proxy-Authorization: Basic dXNlcjpzZWNyZXQ=\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: tunneled\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("connect response = %v, %v", resp, err)
+	}
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("tunneled response error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello tunneled" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestHTTPProxyAuthRequired(t *testing.T) {
+	backend := newBackend(t)
+	proxyAddr := startHTTPProxy(t, backend.Listener.Addr().String(), socks5.StaticCredentials{"user": "secret"})
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}}
+	resp, err := client.Get("http://service.nostr/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusProxyAuthRequired)
+	}
+	if resp.Header.Get("Proxy-Authenticate") != proxyAuthRealm {
+		t.Errorf("Proxy-Authenticate = %q", resp.Header.Get("Proxy-Authenticate"))
+	}
+}
+
+func TestHTTPProxyNostrToken(t *testing.T) {
+	const realm = "127.0.0.1:8882"
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, _ := nostr.GetPublicKey(privateKey)
+	npub, _ := nip19.EncodePublicKey(publicKey)
+	publicKeys, err := socks5.NewStaticPublicKeys(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newBackend(t)
+	proxyAddr := startHTTPProxy(t, backend.Listener.Addr().String(),
+		&socks5.NostrTokenCredentials{Realm: realm, PublicKeys: publicKeys})
+	token, err := socks5.NewNostrToken(privateKey, realm, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(client *http.Client) int {
+		t.Helper()
+		resp, err := client.Get("http://service.nostr/")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	newClient := func() *http.Client {
+		proxyURL := &url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword(npub, token)}
+		return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	}
+	// the requests of a keep-alive connection may present the same token
+	client := newClient()
+	for i := 0; i < 2; i++ {
+		if status := get(client); status != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+	// another connection cannot replay it
+	if status := get(newClient()); status != http.StatusProxyAuthRequired {
+		t.Errorf("replayed token status = %d, want %d", status, http.StatusProxyAuthRequired)
+	}
+}
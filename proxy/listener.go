@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/asmogo/nws/socks5"
+)
+
+const (
+	networkTCP  = "tcp"
+	networkUnix = "unix"
+
+	protocolSOCKS5      = "socks5"
+	protocolHTTP        = "http"
+	protocolTransparent = "transparent"
+	protocolTProxy      = "tproxy"
+
+	// unixSocketMode restricts unix sockets to the user running the entry node.
+	unixSocketMode = 0o600
+)
+
+var errInvalidListener = errors.New("invalid listener address")
+
+// listener is a listen address of the entry node.
+type listener struct {
+	network string
+	address string
+	// protocol is the proxy protocol spoken on the listener: socks5, http, transparent or tproxy.
+	protocol string
+	// credentials enable username/password authentication for this listener if set.
+	credentials socks5.StaticCredentials
+}
+
+// parseListener parses a listener address in the form "tcp://[user:password@]host:port",
+// "unix://[user:password@]/path/to/socket" or "http://[user:password@]host:port".
+// The http scheme serves a HTTP proxy, all others SOCKS5. Addresses without a scheme are treated as tcp.
+// The transparent and tproxy schemes ("transparent://host:port") accept connections redirected by
+// iptables or nftables REDIRECT and TPROXY rules on Linux. They do not support credentials.
+func parseListener(spec string) (*listener, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme == "" || u.Opaque != "" {
+		// host:port is either not a url at all or parsed with the host as scheme
+		if _, _, splitErr := net.SplitHostPort(spec); splitErr != nil {
+			return nil, fmt.Errorf("%w %q", errInvalidListener, spec)
+		}
+		return &listener{network: networkTCP, address: spec, protocol: protocolSOCKS5}, nil
+	}
+	l := &listener{network: u.Scheme, protocol: protocolSOCKS5}
+	switch u.Scheme {
+	case protocolHTTP, protocolTransparent, protocolTProxy:
+		l.network, l.protocol = networkTCP, u.Scheme
+		fallthrough
+	case networkTCP:
+		if _, _, err = net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("%w %q: %w", errInvalidListener, spec, err)
+		}
+		l.address = u.Host
+	case networkUnix:
+		if u.Path == "" {
+			return nil, fmt.Errorf("%w %q: missing socket path", errInvalidListener, spec)
+		}
+		l.address = u.Path
+	default:
+		return nil, fmt.Errorf("%w %q: unsupported scheme %s", errInvalidListener, spec, u.Scheme)
+	}
+	if l.transparent() && u.User != nil {
+		return nil, fmt.Errorf("%w %q: %s listeners do not support credentials", errInvalidListener, spec, u.Scheme)
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		l.credentials = socks5.StaticCredentials{u.User.Username(): password}
+	}
+	return l, nil
+}
+
+// listen creates the network listener. Stale unix sockets of a previous run are removed first.
+// Unix sockets are only accessible by the owner, since the listener may carry credentials.
+func (l *listener) listen() (net.Listener, error) {
+	if l.network == networkUnix {
+		if err := removeStaleSocket(l.address); err != nil {
+			return nil, err
+		}
+	}
+	var listenConfig net.ListenConfig
+	if l.transparent() && !transparentSupported {
+		return nil, fmt.Errorf("failed to listen on %s: %w", l, errTransparentUnsupported)
+	}
+	if l.protocol == protocolTProxy {
+		listenConfig.Control = transparentControl
+	}
+	ln, err := listenConfig.Listen(context.Background(), l.network, l.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", l, err)
+	}
+	if l.network == networkUnix {
+		if err = os.Chmod(l.address, unixSocketMode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set unix socket permissions: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the unix socket at path. Other files are never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat unix socket: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%w: %s exists and is not a unix socket", errInvalidListener, path)
+	}
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove unix socket: %w", err)
+	}
+	return nil
+}
+
+// authMethods returns the authentication methods of the listener or nil to use the server defaults.
+func (l *listener) authMethods() []socks5.Authenticator {
+	if l.credentials == nil {
+		return nil
+	}
+	return []socks5.Authenticator{&socks5.UserPassAuthenticator{Credentials: l.credentials}}
+}
+
+// transparent reports whether the listener accepts redirected connections.
+func (l *listener) transparent() bool {
+	return l.protocol == protocolTransparent || l.protocol == protocolTProxy
+}
+
+// String returns the listener address without credentials.
+func (l *listener) String() string {
+	if l.protocol != protocolSOCKS5 {
+		return l.protocol + "://" + l.address
+	}
+	return l.network + "://" + l.address
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/socks5"
+)
+
+// nameRewriter rewrites address book names and NIP-05 identifiers to the .nostr domain of their exit node,
+// so exit nodes receive a destination they understand.
+type nameRewriter struct {
+	resolver socks5.NameResolver
+}
+
+func (r nameRewriter) Rewrite(ctx context.Context, req *socks5.Request) (context.Context, *socks5.AddrSpec) {
+	dest := req.DestAddr
+	if !netstr.IsNostrName(dest.FQDN) {
+		return ctx, dest
+	}
+	// the name was resolved before, unless it is the destination of a datagram
+	publicKey, ok := ctx.Value(netstr.TargetPublicKey).(string)
+	if !ok {
+		var err error
+		if ctx, _, err = r.resolver.Resolve(ctx, dest.FQDN); err != nil {
+			return ctx, dest
+		}
+		publicKey, _ = ctx.Value(netstr.TargetPublicKey).(string)
+	}
+	relays, _ := ctx.Value(netstr.TargetRelays).([]string)
+	domain, err := codec.Address{PublicKey: publicKey, Relays: relays}.Encode(codec.FormatDomain)
+	if err != nil {
+		return ctx, dest
+	}
+	return ctx, &socks5.AddrSpec{FQDN: domain, Port: dest.Port}
+}
@@ -2,6 +2,9 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 
 	"github.com/asmogo/nws/config"
@@ -15,29 +18,210 @@ type Proxy struct {
 	// a list of nostr relays to publish events to
 	pool        *nostr.SimplePool
 	socksServer *socks5.Server
+	listeners   []*listener
+	// credentials are the default credentials of listeners without their own, set if authentication is configured.
+	credentials socks5.CredentialStore
+	// dns answers queries for Nostr names with fake ip addresses, if configured.
+	dns     *dnsServer
+	dnsConn net.PacketConn
 }
 
-func New(ctx context.Context, config *config.EntryConfig) *Proxy {
+// New creates a new entry node proxy. It returns an error if the socks server could not be created.
+func New(ctx context.Context, config *config.EntryConfig) (*Proxy, error) {
 	proxy := &Proxy{
 		config: config,
 		pool:   nostr.NewSimplePool(ctx),
 	}
-	socksServer, err := socks5.New(&socks5.Config{
-		Resolver: netstr.NewNostrDNS(proxy.pool, config.NostrRelays),
+	for _, spec := range config.Listeners {
+		l, err := parseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		proxy.listeners = append(proxy.listeners, l)
+	}
+	if len(proxy.listeners) == 0 {
+		return nil, fmt.Errorf("%w: no listeners configured", errInvalidListener)
+	}
+	resolver, err := newResolver(ctx, proxy.pool, config)
+	if err != nil {
+		return nil, err
+	}
+	socksConfig := &socks5.Config{
+		Resolver: resolver,
+		Rewriter: nameRewriter{resolver: resolver},
 		BindIP:   net.IP{0, 0, 0, 0},
-	}, proxy.pool, config)
+	}
+	authMethods, err := proxy.authMethods()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	socksConfig.AuthMethods = authMethods
+	if config.RulesFile != "" {
+		rules, err := socks5.LoadRules(config.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		socksConfig.Rules = rules
+	}
+	if config.RoutesFile != "" {
+		routes, err := socks5.LoadRoutes(config.RoutesFile)
+		if err != nil {
+			return nil, err
+		}
+		socksConfig.Router = routes
+	}
+	if config.DNSListen != "" {
+		fakeIPs, err := netstr.NewFakeIPPool(config.FakeIPNetwork)
+		if err != nil {
+			return nil, err
+		}
+		proxy.dns = &dnsServer{upstream: config.DNSUpstream, resolver: resolver, fakeIPs: fakeIPs}
+		socksConfig.FakeIPs = fakeIPs
+	}
+	if config.TLSTerminate {
+		interceptor, err := newTLSInterceptor(proxy.pool, config.NostrRelays, config.TLSCACertFile, config.TLSCAKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tls interceptor: %w", err)
+		}
+		socksConfig.Interceptor = interceptor
+	}
+	socksServer, err := socks5.New(socksConfig, proxy.pool, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socks server: %w", err)
 	}
 	proxy.socksServer = socksServer
-	return proxy
+	return proxy, nil
 }
 
-// Start should start the server
-func (s *Proxy) Start() error {
-	err := s.socksServer.ListenAndServe("tcp", "8882")
+// newResolver creates the resolver of Nostr destinations, address book names and NIP-05 identifiers.
+func newResolver(ctx context.Context, pool *nostr.SimplePool, config *config.EntryConfig) (*netstr.NostrDNS, error) {
+	addressBook, err := netstr.LoadAddressBook(config.AddressBookFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if config.AddressBookList != "" {
+		if err = addressBook.Subscribe(ctx, pool, config.NostrRelays, config.AddressBookList); err != nil {
+			return nil, err
+		}
+	}
+	nip05 := netstr.NewNIP05Resolver(
+		netstr.WithNIP05Endpoint(config.NIP05Endpoint),
+		netstr.WithNIP05TTL(config.NIP05CacheTTL),
+	)
+	return netstr.NewNostrDNS(pool, config.NostrRelays,
+		netstr.WithAddressBook(addressBook),
+		netstr.WithNIP05(nip05),
+	), nil
+}
+
+// authMethods creates the default authentication methods from the configured credentials file and authorized keys.
+// It returns nil if neither is configured, which disables authentication.
+func (s *Proxy) authMethods() ([]socks5.Authenticator, error) {
+	var stores socks5.CredentialStores
+	if s.config.CredentialsFile != "" {
+		fileCredentials, err := socks5.NewFileCredentials(s.config.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, fileCredentials)
+	}
+	var authMethods []socks5.Authenticator
+	if len(s.config.AuthorizedKeys) > 0 {
+		publicKeys, err := socks5.NewStaticPublicKeys(s.config.AuthorizedKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorized keys: %w", err)
+		}
+		stores = append(stores, &socks5.NostrTokenCredentials{Realm: s.authRealm(), PublicKeys: publicKeys})
+		authMethods = append(authMethods, socks5.NostrAuthenticator{PublicKeys: publicKeys})
+	}
+	if len(stores) == 0 {
+		return nil, nil
+	}
+	s.credentials = stores
+	return append(authMethods, socks5.UserPassAuthenticator{Credentials: stores}), nil
+}
+
+// authRealm returns the realm Nostr tokens are created for: the configured realm, the public address
+// or the address of the first listener.
+func (s *Proxy) authRealm() string {
+	if s.config.AuthRealm != "" {
+		return s.config.AuthRealm
+	}
+	if s.config.PublicAddress != "" {
+		return s.config.PublicAddress
+	}
+	return s.listeners[0].address
+}
+
+// Start starts the socks server on all configured listeners and blocks until one of them fails
+// or the proxy is shut down.
+func (s *Proxy) Start() error {
+	errCh := make(chan error, len(s.listeners)+1)
+	servers := len(s.listeners)
+	if s.dns != nil {
+		conn, err := net.ListenPacket("udp", s.config.DNSListen)
+		if err != nil {
+			return fmt.Errorf("failed to listen for dns queries: %w", err)
+		}
+		s.dnsConn = conn
+		slog.Info("dns server listening", "address", conn.LocalAddr(), "fake_ip_network", s.config.FakeIPNetwork)
+		go func() {
+			errCh <- s.dns.serve(conn)
+		}()
+		servers++
+	}
+	for _, l := range s.listeners {
+		ln, err := l.listen()
+		if err != nil {
+			return err
+		}
+		slog.Info("proxy listening", "address", l.String(), "protocol", l.protocol, "auth", l.credentials != nil || s.credentials != nil)
+		go func(l *listener) {
+			errCh <- s.serve(ln, l)
+		}(l)
+	}
+	for i := 0; i < servers; i++ {
+		err := <-errCh
+		if err != nil && !errors.Is(err, socks5.ErrServerClosed) {
+			return fmt.Errorf("socks server failed: %w", err)
+		}
 	}
 	return nil
 }
+
+func (s *Proxy) serve(ln net.Listener, l *listener) error {
+	if l.transparent() {
+		frontend := &transparentProxy{server: s.socksServer, tproxy: l.protocol == protocolTProxy, addr: ln.Addr()}
+		return s.socksServer.ServeHandler(ln, frontend.serveConn)
+	}
+	if l.protocol == protocolHTTP {
+		frontend := &httpProxy{server: s.socksServer, credentials: s.credentials}
+		if l.credentials != nil {
+			frontend.credentials = l.credentials
+		}
+		return s.socksServer.ServeHandler(ln, frontend.serveConn)
+	}
+	if authMethods := l.authMethods(); authMethods != nil {
+		return s.socksServer.ServeWithAuth(ln, authMethods)
+	}
+	return s.socksServer.Serve(ln)
+}
+
+// Shutdown stops accepting new connections, waits for active sessions to finish until the context is done
+// and closes the relay connections afterwards.
+func (s *Proxy) Shutdown(ctx context.Context) error {
+	err := s.socksServer.Shutdown(ctx)
+	if s.dnsConn != nil {
+		s.dnsConn.Close()
+	}
+	closeRelays(s.pool)
+	return err
+}
+
+// closeRelays closes the relay connections of the pool.
+func closeRelays(pool *nostr.SimplePool) {
+	pool.Relays.Range(func(_ string, relay *nostr.Relay) bool {
+		relay.Close()
+		return true
+	})
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/asmogo/nws/netstr"
+	"github.com/asmogo/nws/socks5"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	httpsPort        = 443
+	caValidity       = 10 * 365 * 24 * time.Hour
+	leafValidity     = 7 * 24 * time.Hour
+	serialNumberBits = 128
+	caKeyFileMode    = 0600
+	caCertFileMode   = 0644
+)
+
+var (
+	errInvalidCA          = errors.New("invalid certificate authority")
+	errServerNameMismatch = errors.New("server name does not match the destination")
+)
+
+// tlsInterceptor terminates TLS connections from clients and re-originates them to the exit node.
+// The exit certificate is pinned to the certificate event published by the exit node,
+// while the client is presented a certificate issued by the local certificate authority.
+type tlsInterceptor struct {
+	pool   *nostr.SimplePool
+	relays []string
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	leaves *xsync.MapOf[string, *tls.Certificate]
+	// pins caches the published certificates by exit public key.
+	pins *xsync.MapOf[string, *x509.Certificate]
+}
+
+func newTLSInterceptor(pool *nostr.SimplePool, relays []string, certFile, keyFile string) (*tlsInterceptor, error) {
+	ca, caKey, err := loadOrCreateCA(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsInterceptor{
+		pool:   pool,
+		relays: relays,
+		ca:     ca,
+		caKey:  caKey,
+		leaves: xsync.NewMapOf[string, *tls.Certificate](),
+		pins:   xsync.NewMapOf[string, *x509.Certificate](),
+	}, nil
+}
+
+// Intercept terminates TLS for nostr destinations on port 443. Other connections are returned unchanged.
+func (t *tlsInterceptor) Intercept(
+	ctx context.Context,
+	req *socks5.Request,
+	client, target net.Conn,
+) (net.Conn, net.Conn, error) {
+	dst := req.RealDestAddr()
+	if dst.Port != httpsPort || dst.FQDN == "" {
+		return client, target, nil
+	}
+	publicKey, relays, err := netstr.ParseDestination(dst.FQDN)
+	if errors.Is(err, netstr.ErrNoNostrDestination) {
+		return client, target, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid destination: %w", err)
+	}
+	if len(relays) == 0 {
+		relays = t.relays
+	}
+	var upstream *tls.Conn
+	downstream := tls.Server(client, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, err := requestedServerName(req.DestAddr.FQDN, dst.FQDN, hello.ServerName)
+			if err != nil {
+				return nil, err
+			}
+			// offer the client protocols to the exit, so both ends agree on the same one
+			upstreamConfig := &tls.Config{
+				InsecureSkipVerify:    true, //nolint:gosec // verification is done by VerifyPeerCertificate
+				VerifyPeerCertificate: t.verifyExit(hello.Context(), publicKey, relays),
+				MinVersion:            tls.VersionTLS12,
+				ServerName:            serverName,
+				NextProtos:            hello.SupportedProtos,
+			}
+			upstream = tls.Client(target, upstreamConfig)
+			if err := upstream.HandshakeContext(hello.Context()); err != nil {
+				return nil, fmt.Errorf("failed to verify exit certificate: %w", err)
+			}
+			leaf, err := t.leafCertificate(serverName)
+			if err != nil {
+				return nil, err
+			}
+			config := &tls.Config{Certificates: []tls.Certificate{*leaf}, MinVersion: tls.VersionTLS12}
+			if protocol := upstream.ConnectionState().NegotiatedProtocol; protocol != "" {
+				config.NextProtos = []string{protocol}
+			}
+			return config, nil
+		},
+	})
+	if err = downstream.HandshakeContext(ctx); err != nil {
+		return nil, nil, fmt.Errorf("tls handshake failed: %w", err)
+	}
+	slog.Info("terminated tls connection", "destination", dst.FQDN)
+	return downstream, upstream, nil
+}
+
+// requestedServerName returns the name the client certificate is issued for.
+// Only the destination of the request is accepted: the requested name, e.g. an address book name,
+// or the .nostr domain it was rewritten to. Clients without SNI get the requested name.
+func requestedServerName(requested, destination, sni string) (string, error) {
+	requested = strings.TrimSuffix(requested, ".")
+	if requested == "" {
+		requested = strings.TrimSuffix(destination, ".")
+	}
+	if sni == "" {
+		return requested, nil
+	}
+	for _, name := range []string{requested, strings.TrimSuffix(destination, ".")} {
+		if strings.EqualFold(sni, name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q does not match destination %q", errServerNameMismatch, sni, requested)
+}
+
+// verifyExit returns a tls.Config.VerifyPeerCertificate function pinning the certificate published by the exit node.
+// The pin is cached and only fetched again once it expired or the exit node presents another certificate.
+func (t *tlsInterceptor) verifyExit(
+	ctx context.Context, publicKey string, relays []string,
+) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if pinned, ok := t.pins.Load(publicKey); ok && time.Now().Before(pinned.NotAfter) {
+			if netstr.VerifyPinnedCertificate(pinned)(rawCerts, verifiedChains) == nil {
+				return nil
+			}
+		}
+		// the exit node may have published a new certificate
+		pinned, err := netstr.FetchCertificate(ctx, t.pool, relays, publicKey)
+		if err != nil {
+			return fmt.Errorf("failed to fetch exit certificate: %w", err)
+		}
+		t.pins.Store(publicKey, pinned)
+		return netstr.VerifyPinnedCertificate(pinned)(rawCerts, verifiedChains)
+	}
+}
+
+// leafCertificate returns a cached certificate for the server name or issues a new one.
+func (t *tlsInterceptor) leafCertificate(serverName string) (*tls.Certificate, error) {
+	if leaf, ok := t.leaves.Load(serverName); ok && time.Now().Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	template, err := certificateTemplate(serverName, leafValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.DNSNames = []string{serverName}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, t.ca, key.Public(), t.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	leaf := &tls.Certificate{
+		Certificate: [][]byte{der, t.ca.Raw},
+		PrivateKey:  key,
+		Leaf:        parsed,
+	}
+	t.leaves.Store(serverName, leaf)
+	return leaf, nil
+}
+
+// loadOrCreateCA loads the local certificate authority from disk.
+// If the files do not exist yet, a new certificate authority is generated and written to disk.
+func loadOrCreateCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		return createCA(certFile, keyFile)
+	}
+	if certErr != nil || keyErr != nil {
+		return nil, nil, fmt.Errorf("failed to read certificate authority: %w", errors.Join(certErr, keyErr))
+	}
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate authority: %w", err)
+	}
+	ca, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate authority: %w", err)
+	}
+	signer, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok || !ca.IsCA {
+		return nil, nil, errInvalidCA
+	}
+	return ca, signer, nil
+}
+
+func createCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	template, err := certificateTemplate("NWS entry CA", caValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate authority: %w", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), caKeyFileMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write private key: %w", err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), caCertFileMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate authority: %w", err)
+	}
+	slog.Warn("created new certificate authority. Add it to the trust store of your clients.", "file", certFile)
+	return ca, key, nil
+}
+
+func certificateTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	notBefore := time.Now().Add(-time.Minute)
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"NWS"},
+			CommonName:   commonName,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		BasicConstraintsValid: true,
+	}, nil
+}
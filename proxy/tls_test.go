@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRequestedServerName(t *testing.T) {
+	tests := []struct {
+		sni     string
+		want    string
+		wantErr bool
+	}{
+		{sni: "", want: "mint.nws"},
+		{sni: "Mint.nws", want: "mint.nws"},
+		{sni: "abc.nostr", want: "abc.nostr"},
+		{sni: "bank.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sni, func(t *testing.T) {
+			got, err := requestedServerName("mint.nws", "abc.nostr", tt.sni)
+			if tt.wantErr {
+				if !errors.Is(err, errServerNameMismatch) {
+					t.Fatalf("requestedServerName() error = %v, want %v", err, errServerNameMismatch)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("requestedServerName() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func testCertificate(t *testing.T, validity time.Duration) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := certificateTemplate("exit", validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyExitCachesPin(t *testing.T) {
+	dir := t.TempDir()
+	interceptor, err := newTLSInterceptor(nostr.NewSimplePool(context.Background()), nil,
+		filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const publicKey = "exit"
+	pinned := testCertificate(t, time.Hour)
+	interceptor.pins.Store(publicKey, pinned)
+	// without relays, every fetch fails, so a successful verification was served by the cache
+	verify := interceptor.verifyExit(context.Background(), publicKey, nil)
+	if err = verify([][]byte{pinned.Raw}, nil); err != nil {
+		t.Fatalf("verify(pinned) error = %v", err)
+	}
+	if err = verify([][]byte{testCertificate(t, time.Hour).Raw}, nil); err == nil {
+		t.Fatal("verify(other certificate) succeeded")
+	}
+	expired := testCertificate(t, -time.Hour)
+	interceptor.pins.Store(publicKey, expired)
+	if err = verify([][]byte{expired.Raw}, nil); err == nil {
+		t.Fatal("verify(expired pin) succeeded without fetching the certificate again")
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/asmogo/nws/socks5"
+)
+
+var (
+	errTransparentUnsupported = errors.New("transparent proxy listeners are only supported on linux")
+	errNotRedirected          = errors.New("connection was not redirected to the transparent proxy")
+)
+
+// transparentProxy is a frontend for TCP connections redirected to the entry node by iptables or nftables.
+// The original destination is dialed through the socks server, so routing, rules and the Nostr transport
+// are the same as for SOCKS5 clients.
+type transparentProxy struct {
+	server *socks5.Server
+	// tproxy is set for connections redirected with TPROXY, whose local address is the original destination.
+	// Otherwise the destination of REDIRECT rules is read with SO_ORIGINAL_DST.
+	tproxy bool
+	// addr is the address of the listener, connections to it were not redirected.
+	addr net.Addr
+}
+
+// serveConn tunnels a single redirected connection to its original destination.
+func (p *transparentProxy) serveConn(ctx context.Context, conn net.Conn) error {
+	dest, err := p.destination(conn)
+	if err != nil {
+		return err
+	}
+	authContext := &socks5.AuthContext{Method: socks5.NoAuth, Payload: map[string]string{}}
+	req, err := newConnectRequest(conn, dest.String(), authContext)
+	if err != nil {
+		return err
+	}
+	target, err := p.server.DialContext(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %v: %w", dest, err)
+	}
+	defer target.Close()
+	return p.server.ProxyConnect(ctx, req, conn, target)
+}
+
+// destination returns the original destination of the connection.
+func (p *transparentProxy) destination(conn net.Conn) (*net.TCPAddr, error) {
+	var dest *net.TCPAddr
+	if p.tproxy {
+		local, ok := conn.LocalAddr().(*net.TCPAddr)
+		if !ok {
+			return nil, fmt.Errorf("%w: not a tcp connection", errNotRedirected)
+		}
+		dest = local
+	} else {
+		var err error
+		if dest, err = originalDestination(conn); err != nil {
+			return nil, err
+		}
+	}
+	// connecting to the listener itself would loop
+	if listener, ok := p.addr.(*net.TCPAddr); ok && dest.Port == listener.Port &&
+		(dest.IP.Equal(listener.IP) || listener.IP.IsUnspecified() && dest.IP.IsLoopback()) {
+		return nil, errNotRedirected
+	}
+	return dest, nil
+}
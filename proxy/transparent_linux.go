@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const (
+	transparentSupported = true
+
+	// soOriginalDst is SO_ORIGINAL_DST of netfilter, IP6T_SO_ORIGINAL_DST has the same value.
+	soOriginalDst = 80
+	// ipv6Transparent is IPV6_TRANSPARENT, which is missing in the syscall package.
+	ipv6Transparent = 75
+)
+
+// originalDestination returns the destination of a connection before it was redirected by a REDIRECT rule.
+func originalDestination(conn net.Conn) (*net.TCPAddr, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a tcp connection", errNotRedirected)
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original destination: %w", err)
+	}
+	ipv4 := tcpConn.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+	var dest *net.TCPAddr
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if ipv4 {
+			// the 16 bytes of IPv6Mreq hold a struct sockaddr_in
+			var addr *syscall.IPv6Mreq
+			if addr, sockErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst); sockErr == nil {
+				dest = &net.TCPAddr{
+					IP:   net.IPv4(addr.Multiaddr[4], addr.Multiaddr[5], addr.Multiaddr[6], addr.Multiaddr[7]),
+					Port: int(binary.BigEndian.Uint16(addr.Multiaddr[2:4])),
+				}
+			}
+			return
+		}
+		// IPv6MTUInfo starts with a struct sockaddr_in6
+		var info *syscall.IPv6MTUInfo
+		if info, sockErr = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, soOriginalDst); sockErr == nil {
+			port := binary.NativeEndian.AppendUint16(nil, info.Addr.Port)
+			dest = &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(binary.BigEndian.Uint16(port))}
+		}
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original destination: %w", err)
+	}
+	return dest, nil
+}
+
+// transparentControl allows a listener to accept connections redirected by TPROXY rules.
+// It requires the CAP_NET_ADMIN capability.
+func transparentControl(network, _ string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if network == "tcp6" {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enable transparent proxy socket option: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestTransparentProxyNotRedirected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tproxy := &transparentProxy{tproxy: true, addr: ln.Addr()}
+	if _, err = tproxy.destination(conn); !errors.Is(err, errNotRedirected) {
+		t.Errorf("destination() error = %v, want %v", err, errNotRedirected)
+	}
+	// without a REDIRECT rule, SO_ORIGINAL_DST either fails or returns the listener itself
+	redirect := &transparentProxy{addr: ln.Addr()}
+	if dest, err := redirect.destination(conn); err == nil {
+		t.Errorf("destination() = %v, want an error", dest)
+	}
+}
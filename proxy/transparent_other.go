@@ -0,0 +1,18 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+)
+
+const transparentSupported = false
+
+func originalDestination(net.Conn) (*net.TCPAddr, error) {
+	return nil, errTransparentUnsupported
+}
+
+func transparentControl(string, string, syscall.RawConn) error {
+	return errTransparentUnsupported
+}
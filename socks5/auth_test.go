@@ -2,9 +2,12 @@ package socks5
 
 import (
 	"bytes"
+	"io"
+	"net"
+	"testing"
+
 	"github.com/asmogo/nws/config"
 	"github.com/nbd-wtf/go-nostr"
-	"testing"
 )
 
 func TestNoAuth(t *testing.T) {
@@ -13,7 +16,7 @@ func TestNoAuth(t *testing.T) {
 	var resp bytes.Buffer
 
 	s, _ := New(&Config{}, &nostr.SimplePool{}, &config.EntryConfig{})
-	ctx, err := s.authenticate(&resp, req)
+	ctx, err := s.authenticate(&resp, req, s.authMethods)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -42,7 +45,7 @@ func TestPasswordAuth_Valid(t *testing.T) {
 
 	s, _ := New(&Config{AuthMethods: []Authenticator{cator}}, &nostr.SimplePool{}, &config.EntryConfig{})
 
-	ctx, err := s.authenticate(&resp, req)
+	ctx, err := s.authenticate(&resp, req, s.authMethods)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -78,7 +81,7 @@ func TestPasswordAuth_Invalid(t *testing.T) {
 	cator := UserPassAuthenticator{Credentials: cred}
 	s, _ := New(&Config{AuthMethods: []Authenticator{cator}}, &nostr.SimplePool{}, &config.EntryConfig{})
 
-	ctx, err := s.authenticate(&resp, req)
+	ctx, err := s.authenticate(&resp, req, s.authMethods)
 	if err != UserAuthFailed {
 		t.Fatalf("err: %v", err)
 	}
@@ -105,7 +108,7 @@ func TestNoSupportedAuth(t *testing.T) {
 
 	s, _ := New(&Config{AuthMethods: []Authenticator{cator}}, &nostr.SimplePool{}, &config.EntryConfig{})
 
-	ctx, err := s.authenticate(&resp, req)
+	ctx, err := s.authenticate(&resp, req, s.authMethods)
 	if err != NoSupportedAuth {
 		t.Fatalf("err: %v", err)
 	}
@@ -119,3 +122,42 @@ func TestNoSupportedAuth(t *testing.T) {
 		t.Fatalf("bad: %v", out)
 	}
 }
+
+func TestNostrAuth(t *testing.T) {
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, _ := nostr.GetPublicKey(privateKey)
+	tests := []struct {
+		name       string
+		publicKeys PublicKeyStore
+		wantErr    bool
+	}{
+		{name: "any key", publicKeys: nil},
+		{name: "authorized key", publicKeys: StaticPublicKeys{publicKey: {}}},
+		{name: "unauthorized key", publicKeys: StaticPublicKeys{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			clientErr := make(chan error, 1)
+			go func() {
+				// skip the method selection, the authenticator sends it with the challenge
+				if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+					clientErr <- err
+					return
+				}
+				clientErr <- AuthenticateNostr(client, privateKey)
+			}()
+			ctx, err := NostrAuthenticator{PublicKeys: tt.publicKeys}.Authenticate(server, server)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err = <-clientErr; (err != nil) != tt.wantErr {
+				t.Fatalf("AuthenticateNostr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && ctx.Payload["PublicKey"] != publicKey {
+				t.Errorf("PublicKey = %s, want %s", ctx.Payload["PublicKey"], publicKey)
+			}
+		})
+	}
+}
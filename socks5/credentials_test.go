@@ -1,7 +1,13 @@
 package socks5
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 func TestStaticCredentials(t *testing.T) {
@@ -22,3 +28,72 @@ func TestStaticCredentials(t *testing.T) {
 		t.Fatalf("expect invalid")
 	}
 }
+
+func TestNostrTokenCredentials(t *testing.T) {
+	const realm = "entry.example.com:8882"
+	privateKey := nostr.GeneratePrivateKey()
+	publicKey, _ := nostr.GetPublicKey(privateKey)
+	npub, _ := nip19.EncodePublicKey(publicKey)
+	otherKey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	newToken := func(realm string, now time.Time) string {
+		t.Helper()
+		token, err := NewNostrToken(privateKey, realm, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	publicKeys, err := NewStaticPublicKeys(npub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &NostrTokenCredentials{Realm: realm, PublicKeys: publicKeys}
+
+	token := newToken(realm, time.Now())
+	if creds.Valid(npub, token[:len(token)-2]+"00") {
+		t.Fatalf("expect tampered token to be invalid")
+	}
+	if creds.Valid(publicKey, token) {
+		t.Fatalf("expect hex username to be invalid")
+	}
+	otherNpub, _ := nip19.EncodePublicKey(otherKey)
+	if creds.Valid(otherNpub, token) {
+		t.Fatalf("expect unauthorized key to be invalid")
+	}
+	if !creds.Valid(npub, token) {
+		t.Fatalf("expect valid")
+	}
+	if creds.Valid(npub, token) {
+		t.Fatalf("expect used token to be invalid")
+	}
+	separator := strings.LastIndex(token, ".")
+	if creds.Valid(npub, token[:separator]+strings.ToUpper(token[separator:])) {
+		t.Fatalf("expect re-encoded used token to be invalid")
+	}
+	if !creds.Valid(npub, newToken(realm, time.Now())) {
+		t.Fatalf("expect new token to be valid")
+	}
+	if creds.Valid(npub, newToken(realm, time.Now().Add(-2*DefaultTokenMaxAge))) {
+		t.Fatalf("expect expired token to be invalid")
+	}
+	if creds.Valid(npub, newToken("other.example.com:8882", time.Now())) {
+		t.Fatalf("expect token of another realm to be invalid")
+	}
+}
+
+func TestNostrTokenCredentialsPrunesUsedTokens(t *testing.T) {
+	creds := &NostrTokenCredentials{}
+	now := time.Now()
+	if err := creds.use([]byte("expired"), now.Add(-time.Second), now.Add(-2*DefaultTokenMaxAge)); err != nil {
+		t.Fatal(err)
+	}
+	if err := creds.use([]byte("valid"), now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := creds.used["expired"]; ok {
+		t.Error("expired token was not pruned")
+	}
+	if err := creds.use([]byte("valid"), now.Add(time.Minute), now); !errors.Is(err, errTokenUsed) {
+		t.Errorf("use() error = %v, want %v", err, errTokenUsed)
+	}
+}
@@ -0,0 +1,227 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fileCheckInterval limits how often FileCredentials checks the file for changes.
+const fileCheckInterval = time.Second
+
+// Limits of argon2id hashes. Hashes outside of them are rejected when the file is loaded.
+const (
+	maxArgon2Memory     = 1 << 20 // KiB
+	maxArgon2Iterations = 64
+	minArgon2SaltLength = 8
+	minArgon2KeyLength  = 16
+	maxArgon2Length     = 128
+)
+
+var errUnsupportedHash = errors.New("unsupported password hash")
+
+// FileCredentials is a credential store backed by an htpasswd style file.
+// Each line holds "user:hash", where hash is a bcrypt or argon2id (PHC format) password hash.
+// Empty lines and lines starting with # are ignored.
+// The file is reloaded when it changes. If the changed file is invalid, the previous credentials are kept.
+type FileCredentials struct {
+	path string
+
+	mu          sync.RWMutex
+	hashes      map[string]string
+	modTime     time.Time
+	size        int64
+	lastChecked time.Time
+}
+
+// NewFileCredentials loads the credentials from the file at path.
+func NewFileCredentials(path string) (*FileCredentials, error) {
+	c := &FileCredentials{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat credentials file: %w", err)
+	}
+	if err = c.load(info); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *FileCredentials) Valid(user, password string) bool {
+	c.reload()
+	c.mu.RLock()
+	hash, ok := c.hashes[user]
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	valid, err := verifyPassword(hash, password)
+	if err != nil {
+		slog.Error("could not verify password", "user", user, "error", err)
+		return false
+	}
+	return valid
+}
+
+// reload loads the file again if its modification time or size changed since the last load.
+func (c *FileCredentials) reload() {
+	c.mu.Lock()
+	if time.Since(c.lastChecked) < fileCheckInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastChecked = time.Now()
+	modTime, size := c.modTime, c.size
+	c.mu.Unlock()
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		slog.Error("could not stat credentials file", "path", c.path, "error", err)
+		return
+	}
+	if info.ModTime().Equal(modTime) && info.Size() == size {
+		return
+	}
+	if err = c.load(info); err != nil {
+		slog.Error("could not reload credentials file, keeping previous credentials", "path", c.path, "error", err)
+		return
+	}
+	slog.Info("reloaded credentials file", "path", c.path)
+}
+
+func (c *FileCredentials) load(info os.FileInfo) error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	hashes, err := parseCredentials(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse credentials file %s: %w", c.path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes = hashes
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+	return nil
+}
+
+func parseCredentials(data []byte) (map[string]string, error) {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", line)
+		}
+		switch {
+		case strings.HasPrefix(hash, "$argon2id$"):
+			if _, err := parseArgon2id(hash); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		case !strings.HasPrefix(hash, "$2"):
+			return nil, fmt.Errorf("line %d: %w", line, errUnsupportedHash)
+		}
+		hashes[user] = hash
+	}
+	return hashes, scanner.Err()
+}
+
+// verifyPassword compares the password with a bcrypt or argon2id hash.
+func verifyPassword(hash, password string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2id(hash, password)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// argon2Params holds the parameters, salt and key of an argon2id hash.
+type argon2Params struct {
+	memory     uint32
+	iterations uint32
+	threads    uint8
+	salt       []byte
+	key        []byte
+}
+
+// parseArgon2id parses a hash in the PHC format "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>".
+// The parameters are limited, so a hash can neither crash nor exhaust the entry node.
+func parseArgon2id(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("%w: malformed argon2id hash", errUnsupportedHash)
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("%w: argon2 version %s", errUnsupportedHash, parts[2])
+	}
+	h := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.threads); err != nil {
+		return nil, fmt.Errorf("%w: argon2 parameters: %w", errUnsupportedHash, err)
+	}
+	switch {
+	case h.threads < 1:
+		return nil, fmt.Errorf("%w: argon2 parallelism must be at least 1", errUnsupportedHash)
+	case h.iterations < 1 || h.iterations > maxArgon2Iterations:
+		return nil, fmt.Errorf("%w: argon2 iterations must be between 1 and %d", errUnsupportedHash, maxArgon2Iterations)
+	case h.memory < 8*uint32(h.threads) || h.memory > maxArgon2Memory:
+		return nil, fmt.Errorf("%w: argon2 memory must be between 8*p and %d KiB", errUnsupportedHash, maxArgon2Memory)
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("%w: argon2 salt: %w", errUnsupportedHash, err)
+	}
+	if len(h.salt) < minArgon2SaltLength || len(h.salt) > maxArgon2Length {
+		return nil, fmt.Errorf("%w: argon2 salt must be %d to %d bytes", errUnsupportedHash,
+			minArgon2SaltLength, maxArgon2Length)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("%w: argon2 key: %w", errUnsupportedHash, err)
+	}
+	if len(h.key) < minArgon2KeyLength || len(h.key) > maxArgon2Length {
+		return nil, fmt.Errorf("%w: argon2 key must be %d to %d bytes", errUnsupportedHash,
+			minArgon2KeyLength, maxArgon2Length)
+	}
+	return h, nil
+}
+
+// verifyArgon2id verifies a hash in the PHC format, see parseArgon2id.
+func verifyArgon2id(hash, password string) (bool, error) {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	derived := argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(derived, h.key) == 1, nil
+}
+
+// CredentialStores is a credential store that accepts credentials valid in any of its stores.
+type CredentialStores []CredentialStore
+
+func (s CredentialStores) Valid(user, password string) bool {
+	for _, store := range s {
+		if store.Valid(user, password) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package socks5
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestFileCredentials(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bar"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := fmt.Sprintf("# team\nfoo:%s\n\nbaz:%s\n", bcryptHash, argon2idHash("qux"))
+	if err = os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := NewFileCredentials(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !creds.Valid("foo", "bar") {
+		t.Fatalf("expect valid bcrypt password")
+	}
+	if !creds.Valid("baz", "qux") {
+		t.Fatalf("expect valid argon2id password")
+	}
+	if creds.Valid("foo", "qux") || creds.Valid("baz", "bar") || creds.Valid("nobody", "bar") {
+		t.Fatalf("expect invalid")
+	}
+
+	// an invalid file keeps the previous credentials
+	if err = os.WriteFile(path, []byte("broken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds.lastChecked = time.Time{}
+	if !creds.Valid("foo", "bar") {
+		t.Fatalf("expect previous credentials after invalid reload")
+	}
+
+	if err = os.WriteFile(path, []byte("baz:"+argon2idHash("new")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds.lastChecked = time.Time{}
+	if creds.Valid("foo", "bar") {
+		t.Fatalf("expect removed user to be invalid after reload")
+	}
+	if !creds.Valid("baz", "new") {
+		t.Fatalf("expect changed password to be valid after reload")
+	}
+}
+
+func TestNewFileCredentialsUnsupportedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("foo:{SHA}abc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileCredentials(path); err == nil {
+		t.Fatal("expected error for unsupported hash")
+	}
+}
+
+func TestNewFileCredentialsInvalidArgon2id(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	hash := func(params, salt, key string) string {
+		return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, salt, key)
+	}
+	tests := map[string]string{
+		"zero iterations":   hash("m=1024,t=0,p=1", salt, key),
+		"zero parallelism":  hash("m=1024,t=1,p=0", salt, key),
+		"huge memory":       hash("m=4294967295,t=1,p=1", salt, key),
+		"too little memory": hash("m=4,t=1,p=1", salt, key),
+		"many iterations":   hash("m=1024,t=100000,p=1", salt, key),
+		"short salt":        hash("m=1024,t=1,p=1", base64.RawStdEncoding.EncodeToString([]byte("salt")), key),
+		"short key":         hash("m=1024,t=1,p=1", salt, base64.RawStdEncoding.EncodeToString([]byte("key"))),
+		"malformed":         "$argon2id$v=19$m=1024,t=1,p=1$" + salt,
+	}
+	for name, hash := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "htpasswd")
+			if err := os.WriteFile(path, []byte("foo:"+hash+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewFileCredentials(path); !errors.Is(err, errUnsupportedHash) {
+				t.Fatalf("NewFileCredentials() error = %v, want %v", err, errUnsupportedHash)
+			}
+		})
+	}
+}
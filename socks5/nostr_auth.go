@@ -0,0 +1,319 @@
+package socks5
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	// NostrAuth is the private authentication method in which the client signs a challenge with its Nostr key.
+	NostrAuth        = uint8(0x80)
+	nostrAuthVersion = uint8(1)
+	challengeSize    = 32
+	publicKeySize    = 32
+	signatureSize    = 64
+	tokenNonceSize   = 16
+
+	// DefaultTokenMaxAge is the default validity of tokens checked by NostrTokenCredentials.
+	DefaultTokenMaxAge = 5 * time.Minute
+
+	challengeDomain = "nws socks5 auth:"
+	tokenDomain     = "nws socks5 token:"
+)
+
+var (
+	errInvalidPublicKey = errors.New("invalid public key")
+	errInvalidToken     = errors.New("invalid token")
+	errTokenUsed        = errors.New("token already used")
+)
+
+// PublicKeyStore decides which Nostr public keys (hex) are granted access.
+type PublicKeyStore interface {
+	Allowed(publicKey string) bool
+}
+
+// StaticPublicKeys enables using a set of hex public keys directly as a public key store.
+type StaticPublicKeys map[string]struct{}
+
+func (s StaticPublicKeys) Allowed(publicKey string) bool {
+	_, ok := s[publicKey]
+	return ok
+}
+
+// NewStaticPublicKeys creates a public key store from npub or hex encoded public keys.
+func NewStaticPublicKeys(keys ...string) (StaticPublicKeys, error) {
+	store := make(StaticPublicKeys, len(keys))
+	for _, key := range keys {
+		publicKey, err := ParsePublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		store[publicKey] = struct{}{}
+	}
+	return store, nil
+}
+
+// ParsePublicKey returns the hex public key of an npub or hex encoded public key.
+func ParsePublicKey(key string) (string, error) {
+	if strings.HasPrefix(key, "npub") {
+		_, value, err := nip19.Decode(key)
+		if err != nil {
+			return "", fmt.Errorf("%w %q: %w", errInvalidPublicKey, key, err)
+		}
+		key = value.(string)
+	}
+	decoded, err := hex.DecodeString(key)
+	if err != nil || len(decoded) != publicKeySize {
+		return "", fmt.Errorf("%w %q", errInvalidPublicKey, key)
+	}
+	if _, err = schnorr.ParsePubKey(decoded); err != nil {
+		return "", fmt.Errorf("%w %q: %w", errInvalidPublicKey, key, err)
+	}
+	return strings.ToLower(key), nil
+}
+
+// NostrAuthenticator is used to handle authentication with Nostr keys.
+// The server sends a random challenge and the client answers with its public key
+// and a schnorr signature of the challenge.
+//
+// Server: version | challenge (32 bytes)
+// Client: version | public key (32 bytes) | signature (64 bytes)
+// Server: version | status
+type NostrAuthenticator struct {
+	// PublicKeys are the keys granted access. If nil, every key that signs the challenge is accepted.
+	PublicKeys PublicKeyStore
+}
+
+func (a NostrAuthenticator) GetCode() uint8 {
+	return NostrAuth
+}
+
+func (a NostrAuthenticator) Authenticate(reader io.Reader, writer io.Writer) (*AuthContext, error) {
+	// Tell the client to use nostr auth and send the challenge
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to create challenge: %w", err)
+	}
+	if _, err := writer.Write(append([]byte{socks5Version, NostrAuth, nostrAuthVersion}, challenge...)); err != nil {
+		return nil, err
+	}
+
+	// Get the public key and signature
+	response := make([]byte, 1+publicKeySize+signatureSize)
+	if _, err := io.ReadFull(reader, response); err != nil {
+		return nil, err
+	}
+	if response[0] != nostrAuthVersion {
+		return nil, fmt.Errorf("Unsupported auth version: %v", response[0])
+	}
+	publicKey := response[1 : 1+publicKeySize]
+	hash := challengeHash(challenge)
+	if !verify(publicKey, response[1+publicKeySize:], hash[:]) ||
+		(a.PublicKeys != nil && !a.PublicKeys.Allowed(hex.EncodeToString(publicKey))) {
+		if _, err := writer.Write([]byte{nostrAuthVersion, authFailure}); err != nil {
+			return nil, err
+		}
+		return nil, UserAuthFailed
+	}
+	if _, err := writer.Write([]byte{nostrAuthVersion, authSuccess}); err != nil {
+		return nil, err
+	}
+	return &AuthContext{NostrAuth, map[string]string{"PublicKey": hex.EncodeToString(publicKey)}}, nil
+}
+
+// AuthenticateNostr performs the client side of the Nostr authentication method.
+// It must be called after the server selected NostrAuth.
+func AuthenticateNostr(conn io.ReadWriter, privateKey string) error {
+	challenge := make([]byte, 1+challengeSize)
+	if _, err := io.ReadFull(conn, challenge); err != nil {
+		return fmt.Errorf("failed to read challenge: %w", err)
+	}
+	if challenge[0] != nostrAuthVersion {
+		return fmt.Errorf("unsupported auth version: %v", challenge[0])
+	}
+	hash := challengeHash(challenge[1:])
+	publicKey, signature, err := sign(privateKey, hash[:])
+	if err != nil {
+		return err
+	}
+	response := append([]byte{nostrAuthVersion}, publicKey...)
+	if _, err = conn.Write(append(response, signature...)); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	status := make([]byte, 2)
+	if _, err = io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("failed to read status: %w", err)
+	}
+	if status[1] != authSuccess {
+		return UserAuthFailed
+	}
+	return nil
+}
+
+// NostrTokenCredentials is a CredentialStore for username/password authentication with Nostr keys.
+// The username is the npub of the client and the password a short-lived token created by NewNostrToken.
+// Tokens are bound to the realm of the entry node and accepted only once, so a token that was seen,
+// e.g. in the cleartext Proxy-Authorization header, cannot be replayed.
+type NostrTokenCredentials struct {
+	// Realm identifies the entry node, e.g. its public address. Tokens must be created for the same realm.
+	Realm string
+	// PublicKeys are the keys granted access. If nil, every key with a valid token is accepted.
+	PublicKeys PublicKeyStore
+	// MaxAge is the maximum age of a token. Defaults to DefaultTokenMaxAge.
+	MaxAge time.Duration
+
+	// mu protects used and pruned
+	mu sync.Mutex
+	// used holds the signatures of accepted tokens until they expire.
+	used   map[string]time.Time
+	pruned time.Time
+}
+
+func (c *NostrTokenCredentials) Valid(user, password string) bool {
+	publicKey, err := ParsePublicKey(user)
+	if err != nil || !strings.HasPrefix(user, "npub") {
+		return false
+	}
+	if c.PublicKeys != nil && !c.PublicKeys.Allowed(publicKey) {
+		return false
+	}
+	now := time.Now()
+	signature, expires, err := verifyToken(publicKey, password, c.Realm, c.maxAge(), now)
+	if err != nil {
+		return false
+	}
+	return c.use(signature, expires, now) == nil
+}
+
+// use marks the token with the signature as used until it expires and fails if it was used before.
+// Schnorr signatures cannot be altered without invalidating them, so they identify a token.
+func (c *NostrTokenCredentials) use(signature []byte, expires, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used == nil {
+		c.used = make(map[string]time.Time)
+	}
+	// expired tokens are rejected anyway, so they are pruned at most once per max age
+	if now.Sub(c.pruned) > c.maxAge() {
+		for used, usedExpires := range c.used {
+			if now.After(usedExpires) {
+				delete(c.used, used)
+			}
+		}
+		c.pruned = now
+	}
+	key := string(signature)
+	if _, ok := c.used[key]; ok {
+		return errTokenUsed
+	}
+	c.used[key] = expires
+	return nil
+}
+
+func (c *NostrTokenCredentials) maxAge() time.Duration {
+	if c.MaxAge <= 0 {
+		return DefaultTokenMaxAge
+	}
+	return c.MaxAge
+}
+
+// NewNostrToken creates a token for the NostrTokenCredentials of the realm
+// in the form "<unix time>.<hex nonce>.<hex signature>". Each token is accepted once.
+func NewNostrToken(privateKey, realm string, now time.Time) (string, error) {
+	nonce := make([]byte, tokenNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to create nonce: %w", err)
+	}
+	claims := strconv.FormatInt(now.Unix(), 10) + "." + hex.EncodeToString(nonce)
+	hash := tokenHash(claims, realm)
+	_, signature, err := sign(privateKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return claims + "." + hex.EncodeToString(signature), nil
+}
+
+// verifyToken checks the token of the public key for the realm and returns its signature and the time it expires.
+func verifyToken(publicKey, token, realm string, maxAge time.Duration, now time.Time) ([]byte, time.Time, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return nil, time.Time{}, errInvalidToken
+	}
+	claims, signature := token[:separator], token[separator+1:]
+	timestamp, nonce, ok := strings.Cut(claims, ".")
+	if !ok {
+		return nil, time.Time{}, errInvalidToken
+	}
+	if nonceBytes, err := hex.DecodeString(nonce); err != nil || len(nonceBytes) != tokenNonceSize {
+		return nil, time.Time{}, fmt.Errorf("%w: invalid nonce", errInvalidToken)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %w", errInvalidToken, err)
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > maxAge || age < -maxAge {
+		return nil, time.Time{}, fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %w", errInvalidPublicKey, err)
+	}
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %w", errInvalidToken, err)
+	}
+	hash := tokenHash(claims, realm)
+	if !verify(publicKeyBytes, signatureBytes, hash[:]) {
+		return nil, time.Time{}, fmt.Errorf("%w: bad signature", errInvalidToken)
+	}
+	return signatureBytes, time.Unix(unix, 0).Add(maxAge), nil
+}
+
+// tokenHash is the signed hash of the token claims for the realm.
+// The claims never contain ':', so the realm is separated unambiguously.
+func tokenHash(claims, realm string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(tokenDomain + claims + ":" + realm))
+}
+
+func challengeHash(challenge []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(challengeDomain), challenge...))
+}
+
+// sign signs the hash with the hex private key and returns the x-only public key and signature.
+func sign(privateKey string, hash []byte) ([]byte, []byte, error) {
+	privateKeyBytes, err := hex.DecodeString(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+	key, _ := btcec.PrivKeyFromBytes(privateKeyBytes)
+	signature, err := schnorr.Sign(key, hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return schnorr.SerializePubKey(key.PubKey()), signature.Serialize(), nil
+}
+
+func verify(publicKey, signature, hash []byte) bool {
+	key, err := schnorr.ParsePubKey(publicKey)
+	if err != nil {
+		return false
+	}
+	sig, err := schnorr.ParseSignature(signature)
+	if err != nil {
+		return false
+	}
+	return sig.Verify(hash, key)
+}
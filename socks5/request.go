@@ -1,13 +1,14 @@
 package socks5
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/asmogo/nws/netstr"
 	"github.com/asmogo/nws/protocol"
@@ -23,6 +24,14 @@ const (
 	ipv6Address      = uint8(4)
 )
 
+// Reply codes used by exit nodes to answer tunneled requests.
+const (
+	SuccessReply       = successReply
+	ServerFailureReply = serverFailure
+	RuleFailureReply   = ruleFailure
+	TTLExpiredReply    = ttlExpired
+)
+
 const (
 	successReply uint8 = iota
 	serverFailure
@@ -37,6 +46,10 @@ const (
 
 var (
 	unrecognizedAddrType = fmt.Errorf("unrecognized address type")
+	// ErrHostUnreachable is returned by DialContext if the destination could not be resolved.
+	ErrHostUnreachable = errors.New("host unreachable")
+	// ErrRuleBlocked is returned by DialContext if the request was rejected by the rules.
+	ErrRuleBlocked = errors.New("blocked by rules")
 )
 
 // AddressRewriter is used to rewrite a destination transparently
@@ -44,6 +57,12 @@ type AddressRewriter interface {
 	Rewrite(ctx context.Context, request *Request) (context.Context, *AddrSpec)
 }
 
+// Interceptor can be used to wrap the client and target connections of a CONNECT request,
+// e.g. to terminate and re-originate TLS. It is invoked after the success reply was sent.
+type Interceptor interface {
+	Intercept(ctx context.Context, req *Request, client, target net.Conn) (net.Conn, net.Conn, error)
+}
+
 // AddrSpec is used to return the target AddrSpec
 // which may be specified as IPv4, IPv6, or a FQDN
 type AddrSpec struct {
@@ -60,9 +79,10 @@ func (a *AddrSpec) String() string {
 }
 
 // Address returns a string suitable to dial; prefer returning IP-based
-// address, fallback to FQDN
+// address, fallback to FQDN. Nostr destinations are returned without port,
+// other FQDNs without IP, e.g. of routed destinations, keep their port.
 func (a AddrSpec) Address() string {
-	if a.IP == nil {
+	if a.IP == nil && isNostrDestination(a.FQDN) {
 		return a.FQDN
 	}
 	if 0 != len(a.IP) {
@@ -85,6 +105,8 @@ type Request struct {
 	DestAddr *AddrSpec
 	// AddrSpec of the actual destination (might be affected by rewrite)
 	realDestAddr *AddrSpec
+	// direct is set if the Router chose to connect without an exit node.
+	direct bool
 }
 
 /*
@@ -93,6 +115,14 @@ type conn interface {
 	RemoteAddr() net.Addr
 }
 */
+// RealDestAddr returns the AddrSpec of the actual destination, which might be affected by rewrite.
+func (r *Request) RealDestAddr() *AddrSpec {
+	if r.realDestAddr == nil {
+		return r.DestAddr
+	}
+	return r.realDestAddr
+}
+
 // NewRequest creates a new Request from the tcp connection
 func NewRequest(bufConn io.Reader) (*Request, error) {
 	// Read the version byte
@@ -122,25 +152,49 @@ func NewRequest(bufConn io.Reader) (*Request, error) {
 
 // handleRequest is used for request processing after authentication
 func (s *Server) handleRequest(req *Request, conn net.Conn) error {
-	ctx := context.Background()
-
-	// Resolve the address if we have a FQDN
-	dest := req.DestAddr
-	var targetPublicKey string
-	if dest.FQDN != "" {
-		ctx_, addr, err := s.config.Resolver.Resolve(ctx, dest.FQDN)
+	// Switch on the command
+	switch req.Command {
+	case ConnectCommand:
+		return s.handleConnect(s.ctx, conn, req)
+	case BindCommand:
+		return s.handleBind(s.ctx, conn, req)
+	case AssociateCommand:
+		ctx, _, err := s.resolve(s.ctx, req)
 		if err != nil {
 			if err := SendReply(conn, hostUnreachable, nil); err != nil {
 				return fmt.Errorf("failed to send reply: %w", err)
 			}
-			return fmt.Errorf("failed to resolve destination '%v': %w", dest.FQDN, err)
+			return err
+		}
+		return s.handleAssociate(ctx, conn, req)
+	default:
+		if err := SendReply(conn, commandNotSupported, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return fmt.Errorf("unsupported command: %d", req.Command)
+	}
+}
+
+// resolve routes or resolves the destination of the request and applies the address rewriter.
+// It returns the public key of the exit node chosen by the Router or the Resolver, if any.
+func (s *Server) resolve(ctx context.Context, req *Request) (context.Context, string, error) {
+	req.DestAddr = s.unmapFakeIP(req.DestAddr)
+	dest := req.DestAddr
+	ctx, targetPublicKey, routed, err := s.route(ctx, req)
+	if err != nil {
+		return ctx, "", err
+	}
+	if dest.FQDN != "" && !routed {
+		ctx_, addr, err := s.config.Resolver.Resolve(ctx, dest.FQDN)
+		if err != nil {
+			return ctx, "", fmt.Errorf("%w '%v': %w", ErrHostUnreachable, dest.FQDN, err)
 		}
 		ctx = ctx_
 		dest.IP = addr
 		if pubKey := ctx.Value(netstr.TargetPublicKey); pubKey != nil {
 			var ok bool
 			if targetPublicKey, ok = pubKey.(string); !ok {
-				return fmt.Errorf("failed to get target public key: %w", err)
+				return ctx, "", fmt.Errorf("failed to get target public key of %v", dest.FQDN)
 			}
 		}
 	}
@@ -150,60 +204,137 @@ func (s *Server) handleRequest(req *Request, conn net.Conn) error {
 	if s.config.Rewriter != nil {
 		ctx, req.realDestAddr = s.config.Rewriter.Rewrite(ctx, req)
 	}
-
-	// Switch on the command
-	switch req.Command {
-	case ConnectCommand:
-		options := netstr.DialOptions{
-			Pool:            s.pool,
-			PublicAddress:   s.config.entryConfig.PublicAddress,
-			ConnectionID:    uuid.New(),
-			TargetPublicKey: targetPublicKey,
-		}
-		return s.handleConnect(ctx, conn, req, options)
-	case BindCommand:
-		return s.handleBind(ctx, conn, req)
-	case AssociateCommand:
-		return s.handleAssociate(ctx, conn, req)
-	default:
-		if err := SendReply(conn, commandNotSupported, nil); err != nil {
-			return fmt.Errorf("failed to send reply: %w", err)
-		}
-		return fmt.Errorf("unsupported command: %d", req.Command)
-	}
+	return ctx, targetPublicKey, nil
 }
 
-// handleConnect is used to handle a connect command
-func (s *Server) handleConnect(ctx context.Context, conn net.Conn, req *Request, options netstr.DialOptions) error {
+// DialContext connects to the destination of a CONNECT request the same way the SOCKS5 frontend does.
+// The destination is resolved, rewritten and checked against the rules before it is dialed through Nostr.
+// Resolution failures wrap ErrHostUnreachable and rejected requests wrap ErrRuleBlocked.
+// Establishing the connection is limited by the context and connectTimeout,
+// the returned connection lives until it is closed.
+func (s *Server) DialContext(ctx context.Context, req *Request) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	ctx, targetPublicKey, err := s.resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
 	// Check if this is allowed
-	if ctx_, ok := s.config.Rules.Allow(ctx, req); !ok {
-		if err := SendReply(conn, ruleFailure, nil); err != nil {
-			return fmt.Errorf("failed to send reply: %w", err)
+	ctx, ok := s.config.Rules.Allow(ctx, req)
+	if !ok {
+		return nil, fmt.Errorf("connect to %v %w", req.DestAddr, ErrRuleBlocked)
+	}
+	if req.direct {
+		var dialer net.Dialer
+		target, err := dialer.DialContext(ctx, "tcp", req.realDestAddr.Address())
+		if err != nil {
+			return nil, fmt.Errorf("connect to %v failed: %w", req.DestAddr, err)
 		}
-		return fmt.Errorf("connect to %v blocked by rules", req.DestAddr)
-	} else {
-		ctx = ctx_
+		return target, nil
+	}
+	// with a public address, the exit node connects back to the entry node instead of using Nostr
+	if s.tcpListener == nil || s.config.Dial != nil {
+		return s.dial(ctx, req, targetPublicKey, protocol.MessageConnect)
 	}
 	ch := make(chan net.Conn)
-	// Attempt to connect
+	connectionID := uuid.New()
+	s.tcpListener.AddConnectChannel(connectionID, ch)
+	target, err := s.dialWithID(ctx, req, targetPublicKey, protocol.MessageConnectReverse, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	// wait for the connection
+	// in this case, our target needs to be the reversed tcp connection
+	select {
+	case reverse := <-ch:
+		return &reverseConn{Conn: reverse, session: target}, nil
+	case <-ctx.Done():
+		target.Close()
+		return nil, fmt.Errorf("reverse connection to %v: %w", req.DestAddr, ctx.Err())
+	}
+}
+
+// dial opens a Nostr session of the given message type to the destination of the request,
+// unless a custom dial function is configured.
+func (s *Server) dial(
+	ctx context.Context, req *Request, targetPublicKey string, messageType protocol.MessageType,
+) (net.Conn, error) {
+	return s.dialWithID(ctx, req, targetPublicKey, messageType, uuid.New())
+}
 
+func (s *Server) dialWithID(
+	ctx context.Context, req *Request, targetPublicKey string, messageType protocol.MessageType, id uuid.UUID,
+) (net.Conn, error) {
+	if req.direct {
+		return nil, fmt.Errorf("%v to %v: %w", messageType, req.DestAddr, errDirectRouteUnsupported)
+	}
 	dial := s.config.Dial
 	if dial == nil {
-		if s.tcpListener != nil {
-			s.tcpListener.AddConnectChannel(options.ConnectionID, ch)
-			options.MessageType = protocol.MessageConnectReverse
-		} else {
-			options.MessageType = protocol.MessageConnect
+		relays, _ := ctx.Value(netstr.TargetRelays).([]string)
+		dial = netstr.DialSocks(netstr.DialOptions{
+			Pool:            s.pool,
+			PublicAddress:   s.config.entryConfig.PublicAddress,
+			ConnectionID:    id,
+			MessageType:     messageType,
+			TargetPublicKey: targetPublicKey,
+			TargetRelays:    relays,
+		}, s.config.entryConfig)
+	}
+	target, err := dial(ctx, "tcp", req.realDestAddr.Address())
+	if err != nil {
+		return nil, fmt.Errorf("connect to %v failed: %w", req.DestAddr, err)
+	}
+	return target, nil
+}
+
+// reverseConn is a connection the exit node dialed back to the public address of the entry node.
+// Closing it also closes the Nostr session that requested it.
+type reverseConn struct {
+	net.Conn
+	session net.Conn
+}
+
+func (c *reverseConn) Close() error {
+	return errors.Join(c.Conn.Close(), c.session.Close())
+}
+
+// ProxyConnect proxies data between the client and the target of a CONNECT request until
+// one side is closed. The configured Interceptor is applied first.
+func (s *Server) ProxyConnect(ctx context.Context, req *Request, client, target net.Conn) error {
+	// Let the interceptor wrap both ends, e.g. to terminate and re-originate TLS
+	if s.config.Interceptor != nil {
+		var err error
+		client, target, err = s.config.Interceptor.Intercept(ctx, req, client, target)
+		if err != nil {
+			return fmt.Errorf("failed to intercept connection to %v: %w", req.DestAddr, err)
 		}
+		defer target.Close()
+	}
+	// Start proxying
+	errCh := make(chan error, 2)
+	go Proxy(target, client, errCh)
+	go Proxy(client, target, errCh)
 
-		dial = netstr.DialSocks(options, s.config.entryConfig)
+	// Wait
+	for i := 0; i < 2; i++ {
+		e := <-errCh
+		if e != nil {
+			// return from this function closes target (and conn).
+			return e
+		}
 	}
-	ctx, _ = context.WithTimeout(context.Background(), time.Second*10)
-	target, err := dial(ctx, "tcp", req.realDestAddr.Address())
+	return nil
+}
+
+// handleConnect is used to handle a connect command
+func (s *Server) handleConnect(ctx context.Context, conn net.Conn, req *Request) error {
+	target, err := s.DialContext(ctx, req)
 	if err != nil {
 		msg := err.Error()
 		resp := hostUnreachable
-		if strings.Contains(msg, "refused") {
+		if errors.Is(err, ErrRuleBlocked) {
+			resp = ruleFailure
+		} else if strings.Contains(msg, "refused") {
 			resp = connectionRefused
 		} else if strings.Contains(msg, "network is unreachable") {
 			resp = networkUnreachable
@@ -211,41 +342,46 @@ func (s *Server) handleConnect(ctx context.Context, conn net.Conn, req *Request,
 		if err := SendReply(conn, resp, nil); err != nil {
 			return fmt.Errorf("failed to send reply: %v", err)
 		}
-		return fmt.Errorf("connect to %v failed: %v", req.DestAddr, err)
+		return err
 	}
 	defer target.Close()
 
 	// Send success
-	local := target.LocalAddr().(*net.TCPAddr)
-	bind := AddrSpec{IP: local.IP, Port: local.Port}
-	if err := SendReply(conn, successReply, &bind); err != nil {
+	if err := SendReply(conn, successReply, bindAddr(target.LocalAddr())); err != nil {
 		return fmt.Errorf("failed to send reply: %w", err)
 	}
-	// read
-	if options.MessageType == protocol.MessageConnectReverse {
-		// wait for the connection
-		// in this case, our target needs to be the reversed tcp connection
-		target = <-ch
-		defer target.Close()
-	}
-	// Start proxying
-	errCh := make(chan error, 2)
-	go Proxy(target, conn, errCh)
-	go Proxy(conn, target, errCh)
+	return s.ProxyConnect(ctx, req, conn, target)
+}
 
-	// Wait
-	for i := 0; i < 2; i++ {
-		e := <-errCh
-		if e != nil {
-			// return from this function closes target (and conn).
-			return e
-		}
+// bindAddr returns the address reported in the reply to a connect command.
+// Targets without ip address, like Nostr connections, are reported as 0.0.0.0:0.
+func bindAddr(addr net.Addr) *AddrSpec {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return &AddrSpec{IP: addr.IP, Port: addr.Port}
+	case *net.UDPAddr:
+		return &AddrSpec{IP: addr.IP, Port: addr.Port}
+	default:
+		return nil
 	}
-	return nil
 }
 
-// handleBind is used to handle a connect command
+// handleBind is used to handle a bind command.
+// The exit node opens a listening socket and answers with two replies over the Nostr session:
+// the bound address and, once a peer connected, the address of the peer.
+// Both replies are forwarded to the client before data is proxied.
 func (s *Server) handleBind(ctx context.Context, conn net.Conn, req *Request) error {
+	ctx, targetPublicKey, err := s.resolve(ctx, req)
+	if err != nil {
+		resp := hostUnreachable
+		if errors.Is(err, ErrRuleBlocked) {
+			resp = ruleFailure
+		}
+		if err := SendReply(conn, resp, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return err
+	}
 	// Check if this is allowed
 	if ctx_, ok := s.config.Rules.Allow(ctx, req); !ok {
 		if err := SendReply(conn, ruleFailure, nil); err != nil {
@@ -255,29 +391,39 @@ func (s *Server) handleBind(ctx context.Context, conn net.Conn, req *Request) er
 	} else {
 		ctx = ctx_
 	}
-
-	// TODO: Support bind
-	if err := SendReply(conn, commandNotSupported, nil); err != nil {
-		return fmt.Errorf("failed to send reply: %w", err)
+	target, err := s.dial(ctx, req, targetPublicKey, protocol.MessageBind)
+	if err != nil {
+		if err := SendReply(conn, hostUnreachable, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return err
 	}
-	return nil
-}
-
-// handleAssociate is used to handle a connect command
-func (s *Server) handleAssociate(ctx context.Context, conn net.Conn, req *Request) error {
-	// Check if this is allowed
-	if ctx_, ok := s.config.Rules.Allow(ctx, req); !ok {
-		if err := SendReply(conn, ruleFailure, nil); err != nil {
+	defer target.Close()
+	// a nostr connection drops data that does not fit into the read buffer
+	reader := bufio.NewReader(target)
+	for i := 0; i < 2; i++ {
+		resp, addr, err := ReadReply(reader)
+		if err != nil {
+			if err := SendReply(conn, serverFailure, nil); err != nil {
+				return fmt.Errorf("failed to send reply: %w", err)
+			}
+			return fmt.Errorf("failed to read bind reply: %w", err)
+		}
+		if err = SendReply(conn, resp, addr); err != nil {
 			return fmt.Errorf("failed to send reply: %w", err)
 		}
-		return fmt.Errorf("associate to %v blocked by rules", req.DestAddr)
-	} else {
-		ctx = ctx_
+		if resp != successReply {
+			return fmt.Errorf("bind to %v failed with reply %d", req.DestAddr, resp)
+		}
 	}
-
-	// TODO: Support associate
-	if err := SendReply(conn, commandNotSupported, nil); err != nil {
-		return fmt.Errorf("failed to send reply: %w", err)
+	// Start proxying
+	errCh := make(chan error, 2)
+	go Proxy(target, conn, errCh)
+	go Proxy(conn, reader, errCh)
+	for i := 0; i < 2; i++ {
+		if e := <-errCh; e != nil {
+			return e
+		}
 	}
 	return nil
 }
@@ -387,6 +533,22 @@ type closeWriter interface {
 	CloseWrite() error
 }
 
+// ReadReply is used to read a reply message, as sent by SendReply.
+func ReadReply(r io.Reader) (uint8, *AddrSpec, error) {
+	header := []byte{0, 0, 0}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, fmt.Errorf("failed to read reply header: %w", err)
+	}
+	if header[0] != socks5Version {
+		return 0, nil, fmt.Errorf("unsupported reply version: %v", header[0])
+	}
+	addr, err := readAddrSpec(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read reply address: %w", err)
+	}
+	return header[1], addr, nil
+}
+
 // Proxy is used to shuffle data from src to destination, and sends errors
 // down a dedicated channel
 func Proxy(dst io.Writer, src io.Reader, errCh chan error) {
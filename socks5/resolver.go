@@ -21,3 +21,20 @@ func (d DNSResolver) Resolve(ctx context.Context, name string) (context.Context,
 	}
 	return ctx, addr.IP, err
 }
+
+// FakeIPs maps the fake ip addresses handed out by a DNS server back to the names they stand for.
+type FakeIPs interface {
+	Lookup(ip net.IP) (string, bool)
+}
+
+// unmapFakeIP replaces a fake ip address destination with the name it was assigned to.
+func (s *Server) unmapFakeIP(dest *AddrSpec) *AddrSpec {
+	if s.config.FakeIPs == nil || dest.FQDN != "" {
+		return dest
+	}
+	name, ok := s.config.FakeIPs.Lookup(dest.IP)
+	if !ok {
+		return dest
+	}
+	return &AddrSpec{FQDN: name, Port: dest.Port}
+}
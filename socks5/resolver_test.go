@@ -1,6 +1,7 @@
 package socks5
 
 import (
+	"net"
 	"testing"
 
 	"context"
@@ -19,3 +20,23 @@ func TestDNSResolver(t *testing.T) {
 		t.Fatalf("expected loopback")
 	}
 }
+
+type staticFakeIPs map[string]string
+
+func (f staticFakeIPs) Lookup(ip net.IP) (string, bool) {
+	name, ok := f[ip.String()]
+	return name, ok
+}
+
+func TestUnmapFakeIP(t *testing.T) {
+	s := &Server{config: &Config{FakeIPs: staticFakeIPs{"127.192.0.1": "mint.nws"}}}
+
+	dest := s.unmapFakeIP(&AddrSpec{IP: net.IPv4(127, 192, 0, 1), Port: 443})
+	if dest.FQDN != "mint.nws" || dest.Port != 443 || dest.IP != nil {
+		t.Fatalf("unmapFakeIP() = %v, want mint.nws:443", dest)
+	}
+	dest = s.unmapFakeIP(&AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	if dest.FQDN != "" || !dest.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("unmapFakeIP() = %v, want the original address", dest)
+	}
+}
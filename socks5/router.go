@@ -0,0 +1,229 @@
+package socks5
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/netstr"
+)
+
+// RouteAction decides how a destination is reached.
+type RouteAction string
+
+const (
+	// RouteExit relays the connection through an exit node.
+	RouteExit RouteAction = "exit"
+	// RouteDirect connects to the destination from the entry node, without Nostr.
+	RouteDirect RouteAction = "direct"
+	// RouteReject rejects the request.
+	RouteReject RouteAction = "reject"
+)
+
+var errDirectRouteUnsupported = errors.New("direct routes only support connect")
+
+// Route is the route of a destination.
+type Route struct {
+	Action RouteAction
+	// PublicKey is the exit node of RouteExit routes.
+	PublicKey string
+	// Relays of the exit node. If empty, the configured relays are used.
+	Relays []string
+}
+
+// Router is used to choose the route of destinations outside the Nostr namespace
+// (clearnet domains and ip addresses, but not address book names or NIP-05 identifiers). It returns false if no route matches,
+// in which case the destination is resolved by the NameResolver.
+type Router interface {
+	Route(ctx context.Context, req *Request) (Route, bool)
+}
+
+// RoutesConfig is the configuration of a RouteTable, usually loaded from a JSON file.
+//
+//	{
+//	  "groups": {"company": ["npub1...", "nprofile1..."]},
+//	  "routes": [
+//	    {"hosts": ["*.internal.example"], "exit": "company"},
+//	    {"hosts": ["localhost", "*.lan"], "action": "direct"},
+//	    {"hosts": ["*"], "exit": "npub1..."}
+//	  ]
+//	}
+type RoutesConfig struct {
+	// Groups are named sets of exit nodes (npub, nprofile or hex public keys).
+	// Connections are spread randomly across the exit nodes of a group.
+	Groups map[string][]string `json:"groups"`
+	// Routes are evaluated in order, the first route with a matching host pattern is used.
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig maps destination host patterns to a route.
+type RouteConfig struct {
+	// Hosts are glob patterns of the destination domain or ip address, e.g. "*.internal.example".
+	Hosts []string `json:"hosts"`
+	// Action defaults to exit.
+	Action RouteAction `json:"action"`
+	// Exit is the name of an exit group or a single exit node (npub, nprofile or hex public key).
+	Exit string `json:"exit"`
+}
+
+// RouteTable is a Router that matches destination hosts against glob patterns.
+type RouteTable struct {
+	routes []tableRoute
+}
+
+type tableRoute struct {
+	hosts  []string
+	action RouteAction
+	exits  []Route
+}
+
+// LoadRoutes creates a RouteTable from the JSON file at path.
+func LoadRoutes(path string) (*RouteTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+	var config RoutesConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse routes file %s: %w", path, err)
+	}
+	return NewRouteTable(config)
+}
+
+// NewRouteTable validates the configuration and creates a RouteTable.
+func NewRouteTable(config RoutesConfig) (*RouteTable, error) {
+	groups := make(map[string][]Route, len(config.Groups))
+	for name, members := range config.Groups {
+		if len(members) == 0 {
+			return nil, fmt.Errorf("exit group %q is empty", name)
+		}
+		for _, member := range members {
+			exit, err := parseExit(member)
+			if err != nil {
+				return nil, fmt.Errorf("exit group %q: %w", name, err)
+			}
+			groups[name] = append(groups[name], exit)
+		}
+	}
+	table := &RouteTable{}
+	for i, routeConfig := range config.Routes {
+		route := tableRoute{action: routeConfig.Action}
+		if route.action == "" {
+			route.action = RouteExit
+		}
+		for _, host := range routeConfig.Hosts {
+			host = strings.ToLower(host)
+			if _, err := path.Match(host, ""); err != nil {
+				return nil, fmt.Errorf("route %d: invalid host pattern %q: %w", i, host, err)
+			}
+			route.hosts = append(route.hosts, host)
+		}
+		switch route.action {
+		case RouteExit:
+			if group, ok := groups[routeConfig.Exit]; ok {
+				route.exits = group
+				break
+			}
+			exit, err := parseExit(routeConfig.Exit)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: unknown exit group or %w", i, err)
+			}
+			route.exits = []Route{exit}
+		case RouteDirect, RouteReject:
+			if routeConfig.Exit != "" {
+				return nil, fmt.Errorf("route %d: %s routes have no exit", i, route.action)
+			}
+		default:
+			return nil, fmt.Errorf("route %d: unknown action %q", i, route.action)
+		}
+		table.routes = append(table.routes, route)
+	}
+	return table, nil
+}
+
+// parseExit parses an npub, nprofile or hex public key of an exit node.
+func parseExit(exit string) (Route, error) {
+	if strings.HasPrefix(exit, "nprofile") {
+		publicKey, relays, err := netstr.ParseDestination(exit)
+		if err != nil {
+			return Route{}, fmt.Errorf("%w %q: %w", errInvalidPublicKey, exit, err)
+		}
+		return Route{Action: RouteExit, PublicKey: publicKey, Relays: relays}, nil
+	}
+	publicKey, err := ParsePublicKey(exit)
+	if err != nil {
+		return Route{}, err
+	}
+	return Route{Action: RouteExit, PublicKey: publicKey}, nil
+}
+
+func (t *RouteTable) Route(_ context.Context, req *Request) (Route, bool) {
+	host := req.DestAddr.FQDN
+	if host == "" {
+		host = req.DestAddr.IP.String()
+	}
+	host = strings.ToLower(host)
+	for _, route := range t.routes {
+		for _, pattern := range route.hosts {
+			if ok, _ := path.Match(pattern, host); !ok {
+				continue
+			}
+			if route.action != RouteExit {
+				return Route{Action: route.action}, true
+			}
+			return route.exits[rand.Intn(len(route.exits))], true
+		}
+	}
+	return Route{}, false
+}
+
+// route applies the configured Router to destinations outside the Nostr namespace.
+// Exit routes set the target public key and relays in the context, direct routes mark the request to be
+// dialed without Nostr. It returns false if the destination is resolved by the NameResolver instead.
+func (s *Server) route(ctx context.Context, req *Request) (context.Context, string, bool, error) {
+	dest := req.DestAddr
+	// datagrams of an association are routed by their own destination
+	if s.config.Router == nil || req.Command == AssociateCommand {
+		return ctx, "", false, nil
+	}
+	if isNostrDestination(dest.FQDN) {
+		return ctx, "", false, nil
+	}
+	route, ok := s.config.Router.Route(ctx, req)
+	if !ok {
+		return ctx, "", false, nil
+	}
+	slog.Debug("routing request", "destination", dest, "action", route.Action, "exit", route.PublicKey)
+	switch route.Action {
+	case RouteReject:
+		return ctx, "", true, fmt.Errorf("route to %v %w", dest, ErrRuleBlocked)
+	case RouteDirect:
+		req.direct = true
+		if dest.FQDN != "" {
+			_, ip, err := DNSResolver{}.Resolve(ctx, dest.FQDN)
+			if err != nil {
+				return ctx, "", true, fmt.Errorf("%w '%v': %w", ErrHostUnreachable, dest.FQDN, err)
+			}
+			dest.IP = ip
+		}
+		return ctx, "", true, nil
+	default:
+		ctx = context.WithValue(ctx, netstr.TargetPublicKey, route.PublicKey)
+		if len(route.Relays) > 0 {
+			ctx = context.WithValue(ctx, netstr.TargetRelays, route.Relays)
+		}
+		return ctx, route.PublicKey, true, nil
+	}
+}
+
+// isNostrDestination reports whether the host is a .nostr domain, npub, nprofile or a name resolved by NostrDNS.
+func isNostrDestination(host string) bool {
+	return codec.IsAddress(host) || netstr.IsNostrName(host)
+}
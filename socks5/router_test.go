@@ -0,0 +1,134 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asmogo/nws/config"
+	"github.com/asmogo/nws/netstr"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	companyExit = "452ebe58d395b1b196a9b8c82b038b6895cb02b683d0c253a955068dba1facd0"
+	publicExit  = "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+)
+
+func newTestRouteTable(t *testing.T) *RouteTable {
+	t.Helper()
+	companyNprofile, err := nip19.EncodeProfile(companyExit, []string{"wss://company.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := NewRouteTable(RoutesConfig{
+		Groups: map[string][]string{"company": {companyNprofile}},
+		Routes: []RouteConfig{
+			{Hosts: []string{"*.internal.example"}, Exit: "company"},
+			{Hosts: []string{"127.0.0.1", "localhost"}, Action: RouteDirect},
+			{Hosts: []string{"*.ads.example"}, Action: RouteReject},
+			{Hosts: []string{"*"}, Exit: publicExit},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestRouteTable(t *testing.T) {
+	table := newTestRouteTable(t)
+	tests := []struct {
+		dest      *AddrSpec
+		action    RouteAction
+		publicKey string
+	}{
+		{dest: &AddrSpec{FQDN: "git.Internal.example"}, action: RouteExit, publicKey: companyExit},
+		{dest: &AddrSpec{IP: net.IPv4(127, 0, 0, 1)}, action: RouteDirect},
+		{dest: &AddrSpec{FQDN: "tracker.ads.example"}, action: RouteReject},
+		{dest: &AddrSpec{FQDN: "example.com"}, action: RouteExit, publicKey: publicExit},
+	}
+	for _, tt := range tests {
+		route, ok := table.Route(context.Background(), &Request{DestAddr: tt.dest})
+		if !ok || route.Action != tt.action || route.PublicKey != tt.publicKey {
+			t.Errorf("Route(%v) = %+v, %v", tt.dest, route, ok)
+		}
+	}
+	route, _ := table.Route(context.Background(), &Request{DestAddr: &AddrSpec{FQDN: "git.internal.example"}})
+	if len(route.Relays) != 1 || route.Relays[0] != "wss://company.example" {
+		t.Errorf("relays = %v", route.Relays)
+	}
+}
+
+func TestLoadRoutesInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"routes": [{"hosts": ["*"], "exit": "unknown"}]}`,
+		`{"routes": [{"hosts": ["*"], "action": "direct", "exit": "` + publicExit + `"}]}`,
+		`{"routes": [{"hosts": ["*"], "action": "drop"}]}`,
+		`{"groups": {"empty": []}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "routes.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRoutes(path); err == nil {
+			t.Errorf("LoadRoutes(%s) expected error", content)
+		}
+	}
+}
+
+func TestDialContextRoutes(t *testing.T) {
+	exits := make(chan string, 1)
+	addresses := make(chan string, 1)
+	s, err := New(&Config{
+		Router: newTestRouteTable(t),
+		Dial: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			exits <- ctx.Value(netstr.TargetPublicKey).(string)
+			addresses <- addr
+			client, _ := net.Pipe()
+			return client, nil
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := s.DialContext(context.Background(), &Request{Command: ConnectCommand, DestAddr: &AddrSpec{FQDN: "git.internal.example", Port: 443}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Close()
+	if exit := <-exits; exit != companyExit {
+		t.Errorf("exit = %s, want %s", exit, companyExit)
+	}
+	if addr := <-addresses; addr != "git.internal.example:443" {
+		t.Errorf("address = %s, want git.internal.example:443", addr)
+	}
+
+	_, err = s.DialContext(context.Background(), &Request{Command: ConnectCommand, DestAddr: &AddrSpec{FQDN: "tracker.ads.example", Port: 443}})
+	if !errors.Is(err, ErrRuleBlocked) {
+		t.Errorf("DialContext() error = %v, want %v", err, ErrRuleBlocked)
+	}
+
+	// direct routes do not use the exit node dialer
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	target, err = s.DialContext(context.Background(), &Request{Command: ConnectCommand, DestAddr: &AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: port}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Close()
+	select {
+	case exit := <-exits:
+		t.Errorf("direct route dialed exit %s", exit)
+	default:
+	}
+}
@@ -0,0 +1,341 @@
+package socks5
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/asmogo/nws/netstr"
+)
+
+// Action is the decision of a rule.
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+)
+
+// RulesConfig is the configuration of a RuleEngine, usually loaded from a JSON file.
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"name": "team", "users": ["alice"], "hosts": ["*.nostr"], "ports": ["443"]},
+//	    {"name": "no lan", "action": "deny", "networks": ["10.0.0.0/8"]}
+//	  ]
+//	}
+type RulesConfig struct {
+	// Default is the action if no rule matches. Defaults to deny.
+	Default Action `json:"default"`
+	// Rules are evaluated in order, the first matching rule decides.
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig matches requests by the fields that are set. Empty fields match every request.
+type RuleConfig struct {
+	Name string `json:"name"`
+	// Action defaults to allow.
+	Action Action `json:"action"`
+	// Users are the authenticated usernames or Nostr public keys (npub or hex) of the client.
+	Users []string `json:"users"`
+	// Commands are the SOCKS5 commands: connect, bind or associate.
+	Commands []string `json:"commands"`
+	// Hosts are glob patterns of the destination domain, e.g. "*.example.com".
+	Hosts []string `json:"hosts"`
+	// Networks are CIDRs of the destination ip address.
+	Networks []string `json:"networks"`
+	// Ports are destination ports or port ranges, e.g. "443" or "8000-9000".
+	Ports []string `json:"ports"`
+	// PublicKeys are the public keys (npub or hex) of the exit nodes.
+	PublicKeys []string `json:"public_keys"`
+}
+
+// RuleEngine is a RuleSet that matches requests by user, command, destination and exit node.
+type RuleEngine struct {
+	defaultAction Action
+	rules         []rule
+}
+
+type rule struct {
+	name       string
+	action     Action
+	users      map[string]struct{}
+	commands   map[uint8]struct{}
+	hosts      []string
+	networks   []*net.IPNet
+	ports      []portRange
+	publicKeys map[string]struct{}
+}
+
+type portRange struct {
+	from, to int
+}
+
+var commands = map[string]uint8{
+	"connect":   ConnectCommand,
+	"bind":      BindCommand,
+	"associate": AssociateCommand,
+}
+
+// LoadRules creates a RuleEngine from the JSON file at path.
+func LoadRules(path string) (*RuleEngine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	var config RulesConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+	return NewRuleEngine(config)
+}
+
+// NewRuleEngine validates the configuration and creates a RuleEngine.
+func NewRuleEngine(config RulesConfig) (*RuleEngine, error) {
+	engine := &RuleEngine{defaultAction: ActionDeny}
+	if config.Default != "" {
+		if err := validateAction(config.Default); err != nil {
+			return nil, fmt.Errorf("invalid default action: %w", err)
+		}
+		engine.defaultAction = config.Default
+	}
+	for i, ruleConfig := range config.Rules {
+		r, err := newRule(ruleConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d %q: %w", i, ruleConfig.Name, err)
+		}
+		if r.name == "" {
+			r.name = strconv.Itoa(i)
+		}
+		engine.rules = append(engine.rules, r)
+	}
+	return engine, nil
+}
+
+func validateAction(action Action) error {
+	if action != ActionAllow && action != ActionDeny {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+func newRule(config RuleConfig) (rule, error) {
+	r := rule{name: config.Name, action: ActionAllow}
+	if config.Action != "" {
+		if err := validateAction(config.Action); err != nil {
+			return r, err
+		}
+		r.action = config.Action
+	}
+	if len(config.Users) > 0 {
+		r.users = make(map[string]struct{}, len(config.Users))
+		for _, user := range config.Users {
+			// public keys are compared in hex
+			if publicKey, err := ParsePublicKey(user); err == nil {
+				user = publicKey
+			}
+			r.users[user] = struct{}{}
+		}
+	}
+	if len(config.Commands) > 0 {
+		r.commands = make(map[uint8]struct{}, len(config.Commands))
+		for _, name := range config.Commands {
+			command, ok := commands[strings.ToLower(name)]
+			if !ok {
+				return r, fmt.Errorf("unknown command %q", name)
+			}
+			r.commands[command] = struct{}{}
+		}
+	}
+	for _, host := range config.Hosts {
+		host = strings.ToLower(host)
+		if _, err := path.Match(host, ""); err != nil {
+			return r, fmt.Errorf("invalid host pattern %q: %w", host, err)
+		}
+		r.hosts = append(r.hosts, host)
+	}
+	for _, cidr := range config.Networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return r, fmt.Errorf("invalid network: %w", err)
+		}
+		r.networks = append(r.networks, network)
+	}
+	for _, port := range config.Ports {
+		ports, err := parsePortRange(port)
+		if err != nil {
+			return r, err
+		}
+		r.ports = append(r.ports, ports)
+	}
+	if len(config.PublicKeys) > 0 {
+		r.publicKeys = make(map[string]struct{}, len(config.PublicKeys))
+		for _, key := range config.PublicKeys {
+			publicKey, err := ParsePublicKey(key)
+			if err != nil {
+				return r, err
+			}
+			r.publicKeys[publicKey] = struct{}{}
+		}
+	}
+	return r, nil
+}
+
+func parsePortRange(port string) (portRange, error) {
+	from, to, isRange := strings.Cut(port, "-")
+	if !isRange {
+		to = from
+	}
+	fromPort, err := strconv.Atoi(from)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q: %w", port, err)
+	}
+	toPort, err := strconv.Atoi(to)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q: %w", port, err)
+	}
+	if fromPort < 0 || toPort > 65535 || fromPort > toPort {
+		return portRange{}, fmt.Errorf("invalid port range %q", port)
+	}
+	return portRange{fromPort, toPort}, nil
+}
+
+// Allow evaluates the rules in order and logs the decision.
+func (e *RuleEngine) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	info := newRequestInfo(ctx, req)
+	action, name := e.defaultAction, "default"
+	for _, r := range e.rules {
+		if r.matches(info) {
+			action, name = r.action, r.name
+			break
+		}
+	}
+	attrs := []any{"rule", name, "user", info.user, "destination", req.DestAddr, "exit", info.publicKey}
+	if action == ActionAllow {
+		slog.Debug("rules allowed request", attrs...)
+		return ctx, true
+	}
+	slog.Info("rules denied request", attrs...)
+	return ctx, false
+}
+
+// requestInfo holds the attributes of a request the rules match on.
+type requestInfo struct {
+	command   uint8
+	user      string
+	users     []string
+	host      string
+	ip        net.IP
+	port      int
+	publicKey string
+}
+
+func newRequestInfo(ctx context.Context, req *Request) requestInfo {
+	info := requestInfo{command: req.Command}
+	if req.AuthContext != nil {
+		for _, key := range []string{"Username", "PublicKey"} {
+			value, ok := req.AuthContext.Payload[key]
+			if !ok {
+				continue
+			}
+			if info.user == "" {
+				info.user = value
+			}
+			if publicKey, err := ParsePublicKey(value); err == nil {
+				value = publicKey
+			}
+			info.users = append(info.users, value)
+		}
+	}
+	if dest := req.DestAddr; dest != nil {
+		info.host = strings.ToLower(dest.FQDN)
+		info.ip = dest.IP
+		info.port = dest.Port
+	}
+	if publicKey, ok := ctx.Value(netstr.TargetPublicKey).(string); ok {
+		info.publicKey = publicKey
+	} else if publicKey, _, err := netstr.ParseDestination(info.host); err == nil {
+		info.publicKey = publicKey
+	}
+	return info
+}
+
+func (r rule) matches(info requestInfo) bool {
+	return r.matchesUser(info) &&
+		r.matchesCommand(info) &&
+		r.matchesHost(info) &&
+		r.matchesNetwork(info) &&
+		r.matchesPort(info) &&
+		r.matchesPublicKey(info)
+}
+
+func (r rule) matchesUser(info requestInfo) bool {
+	if r.users == nil {
+		return true
+	}
+	for _, user := range info.users {
+		if _, ok := r.users[user]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) matchesCommand(info requestInfo) bool {
+	if r.commands == nil {
+		return true
+	}
+	_, ok := r.commands[info.command]
+	return ok
+}
+
+func (r rule) matchesHost(info requestInfo) bool {
+	if r.hosts == nil {
+		return true
+	}
+	for _, pattern := range r.hosts {
+		if ok, _ := path.Match(pattern, info.host); ok && info.host != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) matchesNetwork(info requestInfo) bool {
+	if r.networks == nil {
+		return true
+	}
+	for _, network := range r.networks {
+		if info.ip != nil && network.Contains(info.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) matchesPort(info requestInfo) bool {
+	if r.ports == nil {
+		return true
+	}
+	for _, ports := range r.ports {
+		if info.port >= ports.from && info.port <= ports.to {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) matchesPublicKey(info requestInfo) bool {
+	if r.publicKeys == nil {
+		return true
+	}
+	_, ok := r.publicKeys[info.publicKey]
+	return ok
+}
@@ -0,0 +1,126 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asmogo/nws/netstr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestRuleEngine(t *testing.T) {
+	const exitKey = "452ebe58d395b1b196a9b8c82b038b6895cb02b683d0c253a955068dba1facd0"
+	exitNpub, err := nip19.EncodePublicKey(exitKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewRuleEngine(RulesConfig{
+		Rules: []RuleConfig{
+			{Name: "no lan", Action: ActionDeny, Networks: []string{"10.0.0.0/8"}},
+			{Name: "admin", Users: []string{"admin"}},
+			{Name: "team exit", Users: []string{"alice"}, PublicKeys: []string{exitNpub}, Ports: []string{"443", "8000-9000"}},
+			{Name: "public web", Commands: []string{"connect"}, Hosts: []string{"*.example.com"}, Ports: []string{"443"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := func(name string) *AuthContext {
+		return &AuthContext{UserPassAuth, map[string]string{"Username": name}}
+	}
+	exitCtx := context.WithValue(context.Background(), netstr.TargetPublicKey, exitKey)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *Request
+		want bool
+	}{
+		{
+			name: "denied network before user rule",
+			req:  &Request{Command: ConnectCommand, AuthContext: user("admin"), DestAddr: &AddrSpec{IP: net.IPv4(10, 1, 2, 3), Port: 80}},
+		},
+		{
+			name: "admin",
+			req:  &Request{Command: BindCommand, AuthContext: user("admin"), DestAddr: &AddrSpec{FQDN: "anything.org", Port: 22}},
+			want: true,
+		},
+		{
+			name: "exit from context",
+			ctx:  exitCtx,
+			req:  &Request{Command: ConnectCommand, AuthContext: user("alice"), DestAddr: &AddrSpec{FQDN: "service.nostr", Port: 8080}},
+			want: true,
+		},
+		{
+			name: "exit from npub destination",
+			req:  &Request{Command: ConnectCommand, AuthContext: user("alice"), DestAddr: &AddrSpec{FQDN: exitNpub, Port: 443}},
+			want: true,
+		},
+		{
+			name: "exit port not allowed",
+			ctx:  exitCtx,
+			req:  &Request{Command: ConnectCommand, AuthContext: user("alice"), DestAddr: &AddrSpec{FQDN: "service.nostr", Port: 22}},
+		},
+		{
+			name: "host glob",
+			req:  &Request{Command: ConnectCommand, DestAddr: &AddrSpec{FQDN: "WWW.Example.com", Port: 443}},
+			want: true,
+		},
+		{
+			name: "host glob wrong command",
+			req:  &Request{Command: AssociateCommand, DestAddr: &AddrSpec{FQDN: "www.example.com", Port: 443}},
+		},
+		{
+			name: "default deny",
+			req:  &Request{Command: ConnectCommand, AuthContext: user("bob"), DestAddr: &AddrSpec{FQDN: "example.com", Port: 443}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if _, got := engine.Allow(ctx, tt.req); got != tt.want {
+				t.Errorf("Allow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(valid, []byte(`{"default": "allow", "rules": [{"action": "deny", "ports": ["25"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := LoadRules(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := engine.Allow(context.Background(), &Request{DestAddr: &AddrSpec{FQDN: "mail.example.com", Port: 25}}); ok {
+		t.Error("expected port 25 to be denied")
+	}
+	if _, ok := engine.Allow(context.Background(), &Request{DestAddr: &AddrSpec{FQDN: "example.com", Port: 443}}); !ok {
+		t.Error("expected default allow")
+	}
+
+	for _, content := range []string{
+		`{"default": "maybe"}`,
+		`{"rules": [{"commands": ["udp"]}]}`,
+		`{"rules": [{"networks": ["10.0.0.0"]}]}`,
+		`{"rules": [{"ports": ["9000-8000"]}]}`,
+		`{"rules": [{"hosts": ["[a"]}]}`,
+		`{"rules": [{"public_keys": ["npub1invalid"]}]}`,
+	} {
+		invalid := filepath.Join(dir, "invalid.json")
+		if err = os.WriteFile(invalid, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadRules(invalid); err == nil {
+			t.Errorf("LoadRules(%s) expected error", content)
+		}
+	}
+}
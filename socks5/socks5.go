@@ -2,12 +2,16 @@ package socks5
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/asmogo/nws/config"
 	"github.com/nbd-wtf/go-nostr"
 	"log"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"context"
 )
@@ -41,6 +45,19 @@ type Config struct {
 	// Defaults to NoRewrite.
 	Rewriter AddressRewriter
 
+	// Router can be used to choose the exit node of clearnet destinations, to connect
+	// directly or to reject them. It is invoked before the Resolver.
+	// Defaults to resolving every destination with the Resolver.
+	Router Router
+
+	// FakeIPs maps destinations in the fake ip network of the DNS server back to their name
+	// before they are routed and resolved. Defaults to no mapping.
+	FakeIPs FakeIPs
+
+	// Interceptor can be used to wrap the connections of a CONNECT request
+	// before data is proxied. Defaults to no interception.
+	Interceptor Interceptor
+
 	// BindIP is used for bind or udp associate
 	BindIP net.IP
 
@@ -51,11 +68,25 @@ type Config struct {
 	// Optional function for dialing out
 	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
 
+	// Optional function for creating the packet connection of UDP ASSOCIATE requests
+	// to the exit node with the given public key. Host is the destination of the first datagram.
+	DialPacket func(ctx context.Context, host, publicKey string) (net.PacketConn, error)
+
 	entryConfig *config.EntryConfig
 }
 
 var ErrorNoServerAvailable = fmt.Errorf("no socks server available")
 
+// ErrServerClosed is returned by Serve and ListenAndServe after a call to Shutdown.
+var ErrServerClosed = errors.New("socks: server closed")
+
+// closeTimeout limits how long Shutdown waits for sessions to send their close messages
+// after their client connections were closed.
+const closeTimeout = 5 * time.Second
+
+// connectTimeout limits resolving and dialing the destination of a CONNECT request.
+const connectTimeout = 10 * time.Second
+
 // Server is reponsible for accepting connections and handling
 // the details of the SOCKS5 protocol
 type Server struct {
@@ -63,6 +94,16 @@ type Server struct {
 	authMethods map[uint8]Authenticator
 	pool        *nostr.SimplePool
 	tcpListener *TCPListener
+
+	// ctx is the base context of all sessions. It is canceled once the server is shut down.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// mu protects listeners and conns
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	sessions  sync.WaitGroup
+	closing   atomic.Bool
 }
 
 // New creates a new Server and potentially returns an error
@@ -94,32 +135,44 @@ func New(conf *Config, pool *nostr.SimplePool, config *config.EntryConfig) (*Ser
 		conf.entryConfig = config
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	server := &Server{
-		config: conf,
-		pool:   pool,
+		config:    conf,
+		pool:      pool,
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
 	}
 	if conf.entryConfig.PublicAddress != "" {
 		// parse host port
 		_, port, err := net.SplitHostPort(conf.entryConfig.PublicAddress)
 		if err != nil {
+			cancel()
 			return nil, fmt.Errorf("failed to parse public address: %w", err)
 		}
 		listener, err := NewTCPListener(net.JoinHostPort(net.IP{0, 0, 0, 0}.String(), port))
 		if err != nil {
+			cancel()
 			return nil, fmt.Errorf("failed to create tcp listener: %w", err)
 		}
 		go listener.Start()
 		server.tcpListener = listener
 	}
-	server.authMethods = make(map[uint8]Authenticator)
-
-	for _, a := range conf.AuthMethods {
-		server.authMethods[a.GetCode()] = a
-	}
+	server.authMethods = authMethodMap(conf.AuthMethods)
 
 	return server, nil
 }
 
+// authMethodMap maps the authenticators by their method code.
+func authMethodMap(authMethods []Authenticator) map[uint8]Authenticator {
+	methods := make(map[uint8]Authenticator, len(authMethods))
+	for _, a := range authMethods {
+		methods[a.GetCode()] = a
+	}
+	return methods
+}
+
 func (s *Server) Configuration() (*Config, error) {
 	if s.config != nil {
 		return s.config, nil
@@ -139,18 +192,140 @@ func (s *Server) ListenAndServe(network, port string) error {
 
 // Serve is used to serve connections from a listener
 func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, func(conn net.Conn) error {
+		return s.serveConn(conn, s.authMethods)
+	})
+}
+
+// ServeWithAuth is used to serve connections from a listener using the given authentication methods
+// instead of the ones of the server configuration. This allows each listener to have its own authentication.
+func (s *Server) ServeWithAuth(l net.Listener, authMethods []Authenticator) error {
+	methods := authMethodMap(authMethods)
+	return s.serve(l, func(conn net.Conn) error {
+		return s.serveConn(conn, methods)
+	})
+}
+
+// ServeHandler is used to serve connections from a listener with another protocol than SOCKS5.
+// The connections are tracked like SOCKS5 sessions, so they are drained and closed on Shutdown.
+// The context passed to the handler is canceled once the server is shut down.
+func (s *Server) ServeHandler(l net.Listener, handler func(ctx context.Context, conn net.Conn) error) error {
+	return s.serve(l, func(conn net.Conn) error {
+		defer conn.Close()
+		return handler(s.ctx, conn)
+	})
+}
+
+func (s *Server) serve(l net.Listener, handle func(conn net.Conn) error) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
 			return err
 		}
-		go s.ServeConn(conn)
+		if !s.trackConn(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.untrackConn(conn)
+			handle(conn)
+		}()
 	}
-	return nil
+}
+
+// Shutdown gracefully shuts down the server.
+// It closes all listeners, so no new sessions are accepted, and waits for active sessions to finish.
+// If the context is done first, the remaining client connections are closed, which closes
+// their Nostr sessions and sends close messages to the exit nodes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing.Store(true)
+	s.mu.Lock()
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+	defer s.cancel()
+	if s.waitForSessions(ctx) {
+		return nil
+	}
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	closeCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	s.waitForSessions(closeCtx)
+	return fmt.Errorf("socks: sessions did not drain: %w", ctx.Err())
+}
+
+// waitForSessions waits until all sessions are done or the context is done.
+// It reports whether all sessions are done.
+func (s *Server) waitForSessions(ctx context.Context) bool {
+	drained := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing.Load() {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing.Load() {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.sessions.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	s.sessions.Done()
 }
 
 // GetAuthContext is used to retrieve the auth context from connection
 func (s *Server) GetAuthContext(conn net.Conn, bufConn *bufio.Reader) (*AuthContext, error) {
+	return s.getAuthContext(conn, bufConn, s.authMethods)
+}
+
+func (s *Server) getAuthContext(conn net.Conn, bufConn *bufio.Reader, authMethods map[uint8]Authenticator) (*AuthContext, error) {
 	// Read the version byte
 	version := []byte{0}
 	if _, err := bufConn.Read(version); err != nil {
@@ -166,7 +341,7 @@ func (s *Server) GetAuthContext(conn net.Conn, bufConn *bufio.Reader) (*AuthCont
 	}
 
 	// Authenticate the connection
-	authContext, err := s.authenticate(conn, bufConn)
+	authContext, err := s.authenticate(conn, bufConn, authMethods)
 	if err != nil {
 		err = fmt.Errorf("Failed to authenticate: %v", err)
 		s.config.Logger.Printf("[ERR] socks: %v", err)
@@ -191,10 +366,14 @@ func (s *Server) GetRequest(conn net.Conn, bufConn *bufio.Reader) (*Request, err
 
 // ServeConn is used to serve a single connection.
 func (s *Server) ServeConn(conn net.Conn) error {
+	return s.serveConn(conn, s.authMethods)
+}
+
+func (s *Server) serveConn(conn net.Conn, authMethods map[uint8]Authenticator) error {
 	s.config.Logger.Print("[INFO] serving socks5 connection")
 	defer conn.Close()
 	bufConn := bufio.NewReader(conn)
-	authContext, err := s.GetAuthContext(conn, bufConn)
+	authContext, err := s.getAuthContext(conn, bufConn, authMethods)
 	if err != nil {
 		return err
 	}
@@ -207,7 +386,8 @@ func (s *Server) ServeConn(conn net.Conn) error {
 	if client, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
 		request.RemoteAddr = &AddrSpec{IP: client.IP, Port: client.Port}
 	}
-	s.config.Logger.Printf("[INFO] handling request from %s", request.RemoteAddr.IP)
+	// unix socket clients have no remote address
+	s.config.Logger.Printf("[INFO] handling request from %s", conn.RemoteAddr())
 	// Process the client request
 	if err := s.handleRequest(request, conn); err != nil {
 		err = fmt.Errorf("failed to handle request: %v", err)
@@ -0,0 +1,258 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/asmogo/nws/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestServerShutdown(t *testing.T) {
+	s, err := New(&Config{}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	// an idle session that never finishes its handshake
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err = <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve() error = %v, want %v", err, ErrServerClosed)
+	}
+	// the session must have been closed by the server
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected client connection to be closed")
+	}
+	if err = s.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve() after Shutdown error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	// the target is not a tcp connection, like a Nostr connection to an exit node
+	s, err := New(&Config{
+		Dial: func(_ context.Context, _, _ string) (net.Conn, error) {
+			target, exit := net.Pipe()
+			go func() {
+				defer exit.Close()
+				io.Copy(exit, exit)
+			}()
+			return target, nil
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(conn)
+
+	go func() {
+		client.Write([]byte{socks5Version, 1, NoAuth})
+		client.Write([]byte{socks5Version, ConnectCommand, 0, ipv4Address, 10, 0, 0, 1, 0, 80})
+	}()
+	auth := make([]byte, 2)
+	if _, err = io.ReadFull(client, auth); err != nil {
+		t.Fatal(err)
+	}
+	resp, addr, err := ReadReply(client)
+	if err != nil {
+		t.Fatalf("ReadReply() error = %v", err)
+	}
+	if resp != successReply || addr.Address() != "0.0.0.0:0" {
+		t.Fatalf("reply = %d %s, want %d 0.0.0.0:0", resp, addr.Address(), successReply)
+	}
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	// the fake exit node reports the bound address and the connecting peer, then echoes data
+	s, err := New(&Config{
+		Dial: func(_ context.Context, _, _ string) (net.Conn, error) {
+			client, exit := net.Pipe()
+			go func() {
+				defer exit.Close()
+				SendReply(exit, successReply, &AddrSpec{IP: net.IPv4(10, 0, 0, 1), Port: 2000})
+				SendReply(exit, successReply, &AddrSpec{IP: net.IPv4(10, 0, 0, 2), Port: 3000})
+				buf := make([]byte, 4)
+				if _, err := io.ReadFull(exit, buf); err == nil {
+					exit.Write(buf)
+				}
+			}()
+			return client, nil
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(conn)
+
+	// pipes are synchronous, so the request is written while the replies are read
+	go func() {
+		client.Write([]byte{socks5Version, 1, NoAuth})
+		client.Write([]byte{socks5Version, BindCommand, 0, ipv4Address, 10, 0, 0, 2, 0, 0})
+	}()
+	auth := make([]byte, 2)
+	if _, err = io.ReadFull(client, auth); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"10.0.0.1:2000", "10.0.0.2:3000"} {
+		resp, addr, err := ReadReply(client)
+		if err != nil {
+			t.Fatalf("ReadReply() error = %v", err)
+		}
+		if resp != successReply || addr.Address() != want {
+			t.Fatalf("reply = %d %s, want %d %s", resp, addr.Address(), successReply, want)
+		}
+	}
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+}
+
+// echoPacketConn is a fake exit node packet connection that echoes every datagram.
+type echoPacketConn struct {
+	net.PacketConn
+	datagrams chan []byte
+	addrs     chan net.Addr
+}
+
+func (c *echoPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.datagrams <- append([]byte(nil), p...)
+	c.addrs <- addr
+	return len(p), nil
+}
+
+func (c *echoPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	datagram, ok := <-c.datagrams
+	if !ok {
+		return 0, nil, io.EOF
+	}
+	return copy(p, datagram), <-c.addrs, nil
+}
+
+func (c *echoPacketConn) Close() error {
+	return nil
+}
+
+func TestAssociate(t *testing.T) {
+	exitKeys := make(chan string, 1)
+	s, err := New(&Config{
+		DialPacket: func(_ context.Context, _, publicKey string) (net.PacketConn, error) {
+			exitKeys <- publicKey
+			return &echoPacketConn{datagrams: make(chan []byte, 1), addrs: make(chan net.Addr, 1)}, nil
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{socks5Version, 1, NoAuth})
+	conn.Write([]byte{socks5Version, AssociateCommand, 0, ipv4Address, 0, 0, 0, 0, 0, 0})
+	if _, err = io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	resp, relay, err := ReadReply(conn)
+	if err != nil || resp != successReply {
+		t.Fatalf("ReadReply() = %d, %v", resp, err)
+	}
+	client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: relay.IP, Port: relay.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	const publicKey = "452ebe58d395b1b196a9b8c82b038b6895cb02b683d0c253a955068dba1facd0"
+	destination, err := nip19.EncodePublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagram, err := buildDatagram(destination+":53", []byte("query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Write(datagram); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, maxDatagramSize)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := client.Read(buffer)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	source, payload, err := parseDatagram(buffer[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.FQDN != destination || source.Port != 53 || string(payload) != "query" {
+		t.Errorf("datagram = %v %q", source, payload)
+	}
+	if exitKey := <-exitKeys; exitKey != publicKey {
+		t.Errorf("exit public key = %s", exitKey)
+	}
+}
+
+func TestDialContextDeadline(t *testing.T) {
+	s, err := New(&Config{
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, errors.New("dial without deadline")
+			}
+			target, exit := net.Pipe()
+			exit.Close()
+			return target, nil
+		},
+	}, &nostr.SimplePool{}, &config.EntryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := s.DialContext(context.Background(), &Request{
+		Command: ConnectCommand, DestAddr: &AddrSpec{IP: net.IPv4(10, 0, 0, 1), Port: 80},
+	})
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	target.Close()
+}
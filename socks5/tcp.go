@@ -42,6 +42,11 @@ func (l *TCPListener) Start() {
 	}
 }
 
+// Close closes the listener. Connections that were already handed over are not closed.
+func (l *TCPListener) Close() error {
+	return l.listener.Close()
+}
+
 // handleConnection handles the connection
 // It reads the uuid from the connection, checks if the uuid is in the map, and sends the connection to the channel
 // It does not close the connection
@@ -0,0 +1,319 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/asmogo/nws/netstr"
+	"github.com/google/uuid"
+)
+
+// maxDatagramSize is the maximum size of an udp datagram.
+const maxDatagramSize = 65535
+
+var errFragmentedDatagram = errors.New("fragmented datagrams are not supported")
+
+// udpAssociation relays the datagrams of a single UDP ASSOCIATE request.
+// Datagrams are sent to the exit node of their destination. Each exit node gets its own packet connection,
+// which the exit node maps to a single udp socket.
+type udpAssociation struct {
+	server *Server
+	req    *Request
+	relay  *net.UDPConn
+
+	// mu protects client, exits, destinations and closed
+	mu sync.Mutex
+	// client is the address of the client, set when the first datagram arrived.
+	client *net.UDPAddr
+	// exits holds the packet connections by exit node public key.
+	exits map[string]net.PacketConn
+	// destinations caches the resolved exit node by destination host.
+	destinations map[string]Route
+	// closed is set once the association is closed, so no packet connections are added afterwards.
+	closed bool
+}
+
+// handleAssociate is used to handle an associate command.
+// The association lives as long as the tcp connection of the request.
+func (s *Server) handleAssociate(ctx context.Context, conn net.Conn, req *Request) error {
+	// Check if this is allowed
+	if ctx_, ok := s.config.Rules.Allow(ctx, req); !ok {
+		if err := SendReply(conn, ruleFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return fmt.Errorf("associate to %v blocked by rules", req.DestAddr)
+	} else {
+		ctx = ctx_
+	}
+	// listen on the interface the client connected to
+	ip := net.IPv4(127, 0, 0, 1)
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		ip = local.IP
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		if err := SendReply(conn, serverFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return fmt.Errorf("failed to listen for udp associate: %w", err)
+	}
+	bind := relay.LocalAddr().(*net.UDPAddr)
+	if err = SendReply(conn, successReply, &AddrSpec{IP: bind.IP, Port: bind.Port}); err != nil {
+		relay.Close()
+		return fmt.Errorf("failed to send reply: %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	association := &udpAssociation{
+		server:       s,
+		req:          req,
+		relay:        relay,
+		exits:        make(map[string]net.PacketConn),
+		destinations: make(map[string]Route),
+	}
+	defer association.close()
+	defer cancel()
+	go association.serve(ctx, conn)
+	// the association ends once the client closes the tcp connection
+	_, err = io.Copy(io.Discard, conn)
+	return err
+}
+
+// serve relays the datagrams of the client to the exit nodes until the relay is closed.
+func (a *udpAssociation) serve(ctx context.Context, conn net.Conn) {
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := a.relay.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		if !a.acceptClient(conn, from) {
+			continue
+		}
+		dest, payload, err := parseDatagram(buffer[:n])
+		if err != nil {
+			a.server.config.Logger.Printf("[ERR] socks: dropping datagram: %v", err)
+			continue
+		}
+		if err = a.forward(ctx, dest, payload); err != nil {
+			a.server.config.Logger.Printf("[ERR] socks: failed to forward datagram to %v: %v", dest, err)
+		}
+	}
+}
+
+// acceptClient checks that the datagram was sent by the client of the association.
+// The first datagram from the ip address of the tcp connection determines the client address.
+func (a *udpAssociation) acceptClient(conn net.Conn, from *net.UDPAddr) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client != nil {
+		return a.client.IP.Equal(from.IP) && a.client.Port == from.Port
+	}
+	if tcpClient, ok := conn.RemoteAddr().(*net.TCPAddr); ok && !tcpClient.IP.Equal(from.IP) {
+		return false
+	}
+	// clients may announce the address they send from
+	if expected := a.req.DestAddr; expected != nil && expected.Port != 0 && expected.Port != from.Port {
+		return false
+	}
+	a.client = from
+	return true
+}
+
+// forward sends the payload to the destination through the exit node of the destination.
+func (a *udpAssociation) forward(ctx context.Context, dest *AddrSpec, payload []byte) error {
+	dest = a.server.unmapFakeIP(dest)
+	// every destination is checked against the rules, like the destination of a CONNECT request
+	if _, ok := a.server.config.Rules.Allow(ctx, &Request{
+		Version:     a.req.Version,
+		Command:     AssociateCommand,
+		AuthContext: a.req.AuthContext,
+		RemoteAddr:  a.req.RemoteAddr,
+		DestAddr:    dest,
+	}); !ok {
+		return fmt.Errorf("datagram to %v %w", dest, ErrRuleBlocked)
+	}
+	// e.g. address book names are rewritten to the domain of their exit node
+	if a.server.config.Rewriter != nil {
+		_, dest = a.server.config.Rewriter.Rewrite(ctx, &Request{Command: AssociateCommand, DestAddr: dest})
+	}
+	host := dest.FQDN
+	if host == "" {
+		host = dest.IP.String()
+	}
+	exit, err := a.exit(ctx, dest, host)
+	if err != nil {
+		return err
+	}
+	_, err = exit.WriteTo(payload, netstr.DatagramAddr(net.JoinHostPort(host, strconv.Itoa(dest.Port))))
+	return err
+}
+
+// exit returns the packet connection to the exit node of the destination host and creates it if needed.
+// Resolving and dialing the exit node happen without holding the lock, so datagrams of other destinations
+// and replies are not blocked by a slow destination.
+func (a *udpAssociation) exit(ctx context.Context, dest *AddrSpec, host string) (net.PacketConn, error) {
+	a.mu.Lock()
+	route, resolved := a.destinations[host]
+	exit, ok := a.exits[route.PublicKey]
+	a.mu.Unlock()
+	if resolved && ok {
+		return exit, nil
+	}
+	if !resolved {
+		var err error
+		if route, err = a.resolveExit(ctx, dest, host); err != nil {
+			return nil, err
+		}
+	}
+	if len(route.Relays) > 0 {
+		ctx = context.WithValue(ctx, netstr.TargetRelays, route.Relays)
+	}
+	exit, err := a.server.dialPacket(ctx, host, route.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		exit.Close()
+		return nil, net.ErrClosed
+	}
+	a.destinations[host] = route
+	if existing, ok := a.exits[route.PublicKey]; ok {
+		// another datagram created the packet connection in the meantime
+		exit.Close()
+		return existing, nil
+	}
+	a.exits[route.PublicKey] = exit
+	go a.receive(route.PublicKey, exit)
+	return exit, nil
+}
+
+// resolveExit returns the exit node that relays datagrams to host.
+func (a *udpAssociation) resolveExit(ctx context.Context, dest *AddrSpec, host string) (Route, error) {
+	if publicKey, relays, err := netstr.ParseDestination(host); err == nil {
+		return Route{Action: RouteExit, PublicKey: publicKey, Relays: relays}, nil
+	}
+	if router := a.server.config.Router; router != nil && !isNostrDestination(host) {
+		if route, ok := router.Route(ctx, &Request{Command: AssociateCommand, DestAddr: dest}); ok {
+			switch route.Action {
+			case RouteReject:
+				return route, fmt.Errorf("route to %v %w", dest, ErrRuleBlocked)
+			case RouteDirect:
+				return route, fmt.Errorf("datagram to %v: %w", dest, errDirectRouteUnsupported)
+			}
+			return route, nil
+		}
+	}
+	ctx, _, err := a.server.config.Resolver.Resolve(ctx, host)
+	if err != nil {
+		return Route{}, fmt.Errorf("%w '%v': %w", ErrHostUnreachable, host, err)
+	}
+	publicKey, _ := ctx.Value(netstr.TargetPublicKey).(string)
+	return Route{Action: RouteExit, PublicKey: publicKey}, nil
+}
+
+// receive relays the datagrams of an exit node back to the client.
+func (a *udpAssociation) receive(publicKey string, exit net.PacketConn) {
+	defer func() {
+		// the exit node released the association, the next datagram creates a new one
+		a.mu.Lock()
+		if a.exits[publicKey] == exit {
+			delete(a.exits, publicKey)
+		}
+		a.mu.Unlock()
+		exit.Close()
+	}()
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := exit.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		datagram, err := buildDatagram(from.String(), buffer[:n])
+		if err != nil {
+			a.server.config.Logger.Printf("[ERR] socks: dropping datagram from %v: %v", from, err)
+			continue
+		}
+		a.mu.Lock()
+		client := a.client
+		a.mu.Unlock()
+		if client == nil {
+			continue
+		}
+		if _, err = a.relay.WriteToUDP(datagram, client); err != nil {
+			return
+		}
+	}
+}
+
+// close closes the relay and all exit node packet connections.
+func (a *udpAssociation) close() {
+	a.relay.Close()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for _, exit := range a.exits {
+		exit.Close()
+	}
+}
+
+// dialPacket creates a packet connection to the exit node with the given public key.
+func (s *Server) dialPacket(ctx context.Context, host, publicKey string) (net.PacketConn, error) {
+	if s.config.DialPacket != nil {
+		return s.config.DialPacket(ctx, host, publicKey)
+	}
+	relays, _ := ctx.Value(netstr.TargetRelays).([]string)
+	return netstr.DialPacket(ctx, netstr.DialOptions{
+		Pool:            s.pool,
+		ConnectionID:    uuid.New(),
+		TargetPublicKey: publicKey,
+		TargetRelays:    relays,
+	}, s.config.entryConfig, host), nil
+}
+
+// parseDatagram parses the header of a SOCKS5 udp datagram and returns the destination and payload.
+func parseDatagram(datagram []byte) (*AddrSpec, []byte, error) {
+	if len(datagram) < 4 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	if datagram[2] != 0 {
+		return nil, nil, errFragmentedDatagram
+	}
+	reader := bytes.NewReader(datagram[3:])
+	dest, err := readAddrSpec(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read datagram address: %w", err)
+	}
+	return dest, datagram[len(datagram)-reader.Len():], nil
+}
+
+// buildDatagram creates a SOCKS5 udp datagram from the "host:port" source address and the payload.
+func buildDatagram(source string, payload []byte) ([]byte, error) {
+	host, port, err := net.SplitHostPort(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source address: %w", err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port: %w", err)
+	}
+	addr := &AddrSpec{Port: portNumber}
+	if addr.IP = net.ParseIP(host); addr.IP == nil {
+		addr.FQDN = host
+	}
+	var buffer bytes.Buffer
+	// the reply format of SendReply equals the datagram header, except for the first three bytes
+	if err = SendReply(&buffer, 0, addr); err != nil {
+		return nil, err
+	}
+	header := buffer.Bytes()
+	header[0], header[1], header[2] = 0, 0, 0
+	return append(header, payload...), nil
+}
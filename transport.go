@@ -0,0 +1,49 @@
+package nws
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/asmogo/nws/codec"
+	"github.com/asmogo/nws/netstr"
+)
+
+// RoundTripper returns a http.RoundTripper that sends requests through the Dialer.
+// The TLS certificate of Nostr destinations is pinned to the certificate published by their exit node,
+// the certificates of other destinations are verified as usual.
+func (d *Dialer) RoundTripper() http.RoundTripper {
+	return &http.Transport{
+		DialContext:    d.DialContext,
+		DialTLSContext: d.dialTLS,
+	}
+}
+
+// dialTLS connects to the address and performs the TLS handshake.
+func (d *Dialer) dialTLS(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	conn, options, err := d.dialTarget(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if codec.IsAddress(host) || netstr.IsNostrName(host) {
+		pinned, err := netstr.FetchCertificate(ctx, d.pool, options.TargetRelays, options.TargetPublicKey)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to fetch exit certificate: %w", err), conn.Close())
+		}
+		config = netstr.PinnedTLSConfig(pinned)
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, errors.Join(fmt.Errorf("tls handshake with %s failed: %w", address, err), conn.Close())
+	}
+	return tlsConn, nil
+}